@@ -4,818 +4,509 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+	_ "time/tzdata" // api.timezone must load on machines without a zoneinfo database
 
 	geojson "github.com/paulmach/go.geojson"
-	// "go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-)
-
-type ResponseWaypoints struct {
-	Status   string `json:"status"`
-	Messages string `json:"messages"`
-	Data     []struct {
-		WpointName    string `json:"WpointName"`
-		WpointNameEng string `json:"WpointNameEng"`
-		WPointNo      string `json:"WPointNo"`
-		Longitude     string `json:"Longitude"`
-		Latitude      string `json:"Latitude"`
-		GroupType     string `json:"group_type"`
-	} `json:"data"`
-}
-
-type ResponseRouteMaster struct {
-	Status   string `json:"status"`
-	Messages string `json:"messages"`
-	Data     []struct {
-		RouteNo        string `json:"RouteNo"`
-		RouteName      string `json:"RouteName"`
-		RouteNum       string `json:"RouteNum"`
-		RouteDirection string `json:"RouteDirection"`
-	} `json:"data"`
-}
-
-type ResponseRouteNo struct {
-	Status   string `json:"status"`
-	Messages string `json:"messages"`
-	Data     []struct {
-		RouteNo                 int         `json:"RouteNo"`
-		RouteName               string      `json:"RouteName"`
-		RouteNum                string      `json:"RouteNum"`
-		RouteDirection          string      `json:"RouteDirection"`
-		RouteStage              interface{} `json:"RouteStage"`
-		TotalCalculatedDistance string      `json:"total_calculated_distance"`
-		RouteDetails            []struct {
-			RDNo       int    `json:"RDNo"`
-			RouteNo    string `json:"RouteNo"`
-			WPointNo   string `json:"WPointNo"`
-			SequenceNo string `json:"SequenceNo"`
-			Waypoints  struct {
-				WPointNo     string        `json:"WPointNo"`
-				WpointName   string        `json:"WpointName"`
-				Longitude    string        `json:"Longitude"`
-				Latitude     string        `json:"Latitude"`
-				InsertedDate string        `json:"InsertedDate"`
-				GroupType    string        `json:"group_type"`
-				InRouteNo    string        `json:"in_route_no"`
-				IsSuspected  string        `json:"is_suspected"`
-				Allvehicle   []interface{} `json:"allvehicle"`
-			} `json:"waypoints"`
-		} `json:"route_details"`
-	} `json:"data"`
-	AllRouteVehicles []interface{} `json:"all_route_vehicles"`
-}
 
-type ResponseBusLocations struct {
-	Status   string `json:"status"`
-	Messages string `json:"messages"`
-	Data     []struct {
-		IdxTrackidPk     int         `json:"idx_Trackid_pk"`
-		VehID            string      `json:"VehId"`
-		CmpID            string      `json:"CmpId"`
-		LastTrackdt      string      `json:"LastTrackdt"`
-		NCSent           string      `json:"NCSent"`
-		CSent            string      `json:"CSent"`
-		PrevTrackDt      string      `json:"PrevTrackDt"`
-		LastNCSentDate   string      `json:"LastNCSentDate"`
-		Longitude        string      `json:"Longitude"`
-		Latitude         string      `json:"Latitude"`
-		City             interface{} `json:"City,omitempty"`
-		Speed            string      `json:"Speed"`
-		ImagePath        interface{} `json:"ImagePath,omitempty"`
-		AC               string      `json:"AC"`
-		Ignition         string      `json:"Ignition"`
-		AUX1             string      `json:"AUX1"`
-		DI4              string      `json:"DI4"`
-		Fuel             string      `json:"Fuel"`
-		Temparature      string      `json:"Temparature"`
-		WPointNo         int         `json:"WPointNo,omitempty"`
-		Odometer         string      `json:"Odometer"`
-		Distance         string      `json:"Distance"`
-		ETATime          string      `json:"ETATime"`
-		ETARoute         string      `json:"ETARoute"`
-		ETAOldTime       string      `json:"ETAOldTime"`
-		Routeflag        string      `json:"routeflag"`
-		ETARouteName     string      `json:"ETARouteName"`
-		DirectionFrom    string      `json:"DirectionFrom"`
-		DirectionTo      string      `json:"DirectionTo"`
-		DispatchDateTime string      `json:"DispatchDateTime"`
-		ETATime1         string      `json:"ETATime1"`
-		ETAOldTime1      string      `json:"ETAOldTime1"`
-		Routeflag1       int         `json:"routeflag1,omitempty"`
-		RouteNo          string      `json:"RouteNo"`
-		WaybillNo        string      `json:"WaybillNo"`
-		Lastwaypointid   int         `json:"lastwaypointid,omitempty"`
-		Token            string      `json:"token"`
-		Avgspeed         string      `json:"avgspeed"`
-		LatLong          string      `json:"LatLong"`
-		GetVehicle       struct {
-			Vehid string `json:"vehid"`
-			VehNo string `json:"VehNo"`
-		} `json:"get_vehicle"`
-	} `json:"data"`
-}
-
-type Data struct {
-	IdxTrackidPk     int                `bson:"idx_Trackid_pk"`
-	VehID            int                `bson:"VehId"`
-	VehNo            string             `bson:"VehNo"`
-	CmpID            int                `bson:"CmpId"`
-	LastTrackdt      primitive.DateTime `bson:"LastTrackdt"`
-	NCSent           string             `bson:"NCSent,omitempty"`
-	CSent            string             `bson:"CSent,omitempty"`
-	PrevTrackDt      primitive.DateTime `bson:"PrevTrackDt"`
-	LastNCSentDate   primitive.DateTime `bson:"LastNCSentDate"`
-	City             interface{}        `bson:"City,omitempty"`
-	Speed            float64            `bson:"Speed"`
-	ImagePath        interface{}        `bson:"ImagePath,omitempty"`
-	AC               bool               `bson:"AC"`
-	Ignition         bool               `bson:"Ignition"`
-	AUX1             bool               `bson:"AUX1"`
-	DI4              bool               `bson:"DI4"`
-	Fuel             float64            `bson:"Fuel,omitempty"`
-	Temparature      string             `bson:"Temperature,omitempty"`
-	WPointNo         int                `bson:"WPointNo,omitempty"`
-	Odometer         float64            `bson:"Odometer"`
-	Distance         float64            `bson:"Distance"`
-	ETATime          float64            `bson:"ETATime"`
-	ETARoute         string             `bson:"ETARoute,omitempty"`
-	ETAOldTime       float64            `bson:"ETAOldTime"`
-	Routeflag        bool               `bson:"routeflag"`
-	ETARouteName     string             `bson:"ETARouteName"`
-	DirectionFrom    string             `bson:"DirectionFrom"`
-	DirectionTo      string             `bson:"DirectionTo"`
-	DispatchDateTime primitive.DateTime `bson:"DispatchDateTime"`
-	ETATime1         float64            `bson:"ETATime1"`
-	ETAOldTime1      float64            `bson:"ETAOldTime1"`
-	Routeflag1       int                `bson:"routeflag1,omitempty"`
-	RouteNo          int                `bson:"RouteNo"`
-	WaybillNo        int                `bson:"WaybillNo"`
-	Lastwaypointid   int                `bson:"lastwaypointid,omitempty"`
-	Token            int                `bson:"token"`
-	Avgspeed         float64            `bson:"avgspeed"`
-	Location         Location
-}
-
-type Location struct {
-	Type        string    `bson:"type"`
-	Coordinates []float64 `bson:"coordinates"`
-}
+	"TMTU/archive"
+	"TMTU/model"
+	"TMTU/store"
+	"TMTU/tmt"
+)
 
 func main() {
-
-	fmt.Println("Choose Which Data you want to store (Choose'1','2','3') Default is FULL functionality ")
-	fmt.Println("1. Bus Stops, Bus Routes, Bus Locations")
-	fmt.Println("2. Bus Stops, Bus Routes")
-	fmt.Printf("3. Bus Locations\n")
-	chooser := 3
-	// fmt.Scanf("%d", &chooser)
-	// err := os.MkdirAll("output", 0750)
-	// if err != nil && !os.IsExist(err) {
-	// 	log.Fatal(err)
-	// }
-
-	switch {
-	case chooser == 1:
-		start := time.Now()
-		fmt.Println("Adding Bus Stops")
-		waypoints()
-		fmt.Printf("\nBus Stops Added in: %s\n", time.Since(start))
-
-		start = time.Now()
-		fmt.Println("Adding Bus Routes")
-		routes()
-		fmt.Printf("\nBus Routes Added in: %s\n", time.Since(start))
-
-		buslocations()
-
-	case chooser == 2:
-		start := time.Now()
-		fmt.Println("Adding Bus Stops")
-		waypoints()
-		fmt.Printf("\nBus Stops Added in: %s\n", time.Since(start))
-
-		start = time.Now()
-		fmt.Println("Adding Bus Routes")
-		routes_unmodified()
-		fmt.Printf("\nBus Routes Added in: %s\n", time.Since(start))
-
-	case chooser == 3:
-		buslocations()
-
-	case chooser == 4:
-		stops()
-
-	default:
-		start := time.Now()
-		fmt.Println("Adding Bus Stops")
-		waypoints()
-		fmt.Printf("\nBus Stops Added in: %s\n", time.Since(start))
-
-		start = time.Now()
-		fmt.Println("Adding Bus Routes")
-		routes()
-		fmt.Printf("\nBus Routes Added in: %s\n", time.Since(start))
-
-		buslocations()
-
-	}
+	os.Exit(run(os.Args[1:], os.Stderr))
 }
 
-func waypoints() {
-	respWaypoints, err := http.Get("http://tmtitsapi.locationtracker.com/api/getWayPoints") //GET request to TMTU for waypoints data
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Println(err)
-		}
-	}(respWaypoints.Body)
-	bodyWaypoints, err := io.ReadAll(respWaypoints.Body) // response body is []byte
+// waypoints saves every bus stop from getWayPoints as GeoJSON for JOSM.
+func waypoints(ctx context.Context, api *tmt.Client, cfg *Config) error {
+	resultWaypoints, err := api.GetWaypoints(ctx) //GET request to TMTU for waypoints data
 	if err != nil {
-		fmt.Println("wrong here")
-	}
-	var resultWaypoints ResponseWaypoints
-	if err := json.Unmarshal(bodyWaypoints, &resultWaypoints); err != nil { // Parse []byte to the go struct pointer
-		fmt.Println(err)
+		return err
 	}
 	waypoints := geojson.NewFeatureCollection()
 	//fmt.Println(resultWaypoints.Data[0].WpointName)
 
 	//Convert the data to geojson format for JOSM
-	for i := 0; i < 721; i++ {
-		sresultWaypointsLatitude, err := strconv.ParseFloat(resultWaypoints.Data[i].Latitude, 64)
-		if err != nil {
-			fmt.Println("wrong here1")
-		}
-		sresultWaypointsLongitude, err := strconv.ParseFloat(resultWaypoints.Data[i].Longitude, 64)
-		if err != nil {
-			fmt.Println("wrong here1")
-		}
-		feature := geojson.NewPointFeature([]float64{sresultWaypointsLongitude, sresultWaypointsLatitude})
-		feature.SetProperty("name", resultWaypoints.Data[i].WpointName)
-		feature.SetProperty("ref", resultWaypoints.Data[i].WPointNo)
+	stops, rejected := validateStops(resultWaypoints.Data)
+	for _, s := range stops {
+		feature := geojson.NewPointFeature(s.stop.Location.Coordinates)
+		feature.SetProperty("name", s.stop.Name)
+		feature.SetProperty("ref", s.raw.WPointNo)
 		feature.SetProperty("highway", "bus_stop")
 		feature.SetProperty("operator", "Thane Municipal Transport")
 		feature.SetProperty("public_transport", "platform")
 		waypoints.AddFeature(feature) //
 	}
+	rejectedPath := filepath.Join(cfg.OutDir, "TMTStopsRejected.json")
+	if len(rejected) > 0 {
+		if err := printRejectedStops(rejected, len(resultWaypoints.Data), rejectedPath); err != nil {
+			fmt.Println(err)
+		}
+	} else {
+		os.Remove(rejectedPath) // from an earlier run
+		fmt.Printf("Stops: all %d saved\n", len(stops))
+	}
 
 	rawJSON, err := waypoints.MarshalJSON()
 	if err != nil {
-		fmt.Printf("error: %v", err)
-		return
+		return err
 	}
 
 	//Saves the geojson file for bus stops to the current directory
 	//fmt.Printf("%s", string(rawJSON))
-	err = os.WriteFile("output/TMTStopsDirect.json", rawJSON, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return os.WriteFile(filepath.Join(cfg.OutDir, "TMTStopsDirect.json"), rawJSON, 0644)
 }
 
-func stops() {
-	var stops = make(map[int]string)
-	var ref []int
-	d, e := os.ReadDir("output")
-	if e != nil {
-		panic(e)
+// stops rebuilds TMTStopsThroughRoutes.json from the raw route files of an
+// earlier "routes -raw" crawl without calling the API.
+func stops(cfg *Config) error {
+	d, err := filepath.Glob(filepath.Join(cfg.OutDir, "TMTRoutes*.json"))
+	if err != nil {
+		return err
 	}
-	waypoints := geojson.NewFeatureCollection()
-	for i := 0; i < len(d)-1; i++ {
+	collector := newStopCollector()
+	for i := 0; i < len(d); i++ {
 		fmt.Println("Restarting...")
 		fmt.Println(i)
-		respRouteNo, err := os.Open("output/" + d[i].Name())
-		if err != nil {
-			fmt.Printf("error: %v", err)
-		}
-		fmt.Println("output/" + d[i].Name())
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
-				fmt.Println(err)
-			}
-		}(respRouteNo)
-		bodyRouteNo, err := io.ReadAll(respRouteNo) // response body is []byte
+		fmt.Println(d[i])
+		bodyRouteNo, err := os.ReadFile(d[i])
 		if err != nil {
-			fmt.Println("wrong here2")
+			fmt.Printf("%v, skipped\n", err)
+			continue
 		}
-		var resultRouteNo ResponseRouteNo
+		var resultRouteNo tmt.ResponseRouteNo
 		if err := json.Unmarshal(bodyRouteNo, &resultRouteNo); err != nil { // Parse []byte to the go struct pointer
-			fmt.Println("wrong here3")
+			fmt.Printf("%s: %v, skipped (not a raw route file?)\n", d[i], err)
+			continue
 		}
-		//fmt.Print(resultRouteNo)
-		routes := geojson.NewFeatureCollection()
-
-		for j := 0; j < len(resultRouteNo.Data[0].RouteDetails); j++ {
-
-			sWaypointNo, err := strconv.ParseInt(resultRouteNo.Data[0].RouteDetails[j].Waypoints.WPointNo, 10, 64)
-			if err != nil {
-				fmt.Println("wrong here15")
-			}
-			flag := 0
-			for k := 0; k < len(stops); k++ {
-
-				if int64(ref[k]) == sWaypointNo {
-					flag = 1
-				}
-			}
-			if flag == 0 {
-				ref = append(ref, int(sWaypointNo))
-			}
-
-			sresultRouteNoLatitude, err := strconv.ParseFloat(resultRouteNo.Data[0].RouteDetails[j].Waypoints.Latitude, 64)
-			if err != nil {
-				fmt.Println("wrong here16")
-			}
-			sresultRouteNoLongitude, err := strconv.ParseFloat(resultRouteNo.Data[0].RouteDetails[j].Waypoints.Longitude, 64)
-			if err != nil {
-				fmt.Println("wrong here1")
-			}
-			feature := geojson.NewPointFeature([]float64{sresultRouteNoLongitude, sresultRouteNoLatitude})
-			feature.SetProperty("name", resultRouteNo.Data[0].RouteDetails[j].Waypoints.WpointName)
-			feature.SetProperty("ref", resultRouteNo.Data[0].RouteDetails[j].Waypoints.WPointNo)
-			feature.SetProperty("position", j)
-			routes.AddFeature(feature)
-
-			for k := 0; k < len(ref); k++ {
-				if ref[k] == int(sWaypointNo) {
-					stops[int(sWaypointNo)] = resultRouteNo.Data[0].RouteDetails[j].Waypoints.WpointName
-					feature1 := geojson.NewPointFeature([]float64{sresultRouteNoLongitude, sresultRouteNoLatitude})
-					feature1.SetProperty("name", resultRouteNo.Data[0].RouteDetails[j].Waypoints.WpointName)
-					feature1.SetProperty("ref", sWaypointNo)
-					feature1.SetProperty("highway", "bus_stop")
-					feature1.SetProperty("operator", "Thane Municipal Transport")
-					feature1.SetProperty("public_transport", "platform")
-					feature1.SetProperty("position", resultRouteNo.Data[0].RouteDetails[j].SequenceNo)
-					feature1.SetProperty("route_num", resultRouteNo.Data[0].RouteNum)
-					feature1.SetProperty("route_direction", resultRouteNo.Data[0].RouteDirection)
-					waypoints.AddFeature(feature1)
-				}
-			}
+		if len(resultRouteNo.Data) == 0 {
+			fmt.Printf("%s: no route in the file, skipped\n", d[i])
+			continue
 		}
+		collector.addReporting(resultRouteNo.Data[0])
 	}
-	rawJSON2, err := waypoints.MarshalJSON()
+	rawJSON2, err := collector.waypoints.MarshalJSON()
 	if err != nil {
-		fmt.Printf("error: %v", err)
-		return
+		return err
 	}
 
 	//Saves the geojson file for bus stops to the current directory
 	//fmt.Printf("%s", string(rawJSON))
-	err = os.WriteFile("output/TMTStopsThroughRoutes.json", rawJSON2, 0644)
-	if err != nil {
-		log.Fatal(err)
+	return os.WriteFile(filepath.Join(cfg.OutDir, "TMTStopsThroughRoutes.json"), rawJSON2, 0644)
+}
+
+// stopCollector gathers the stops of several routes into one GeoJSON
+// collection for TMTStopsThroughRoutes.json. Routes must be added in the
+// same order every time for the file to come out the same.
+type stopCollector struct {
+	waypoints *geojson.FeatureCollection
+}
+
+func newStopCollector() *stopCollector {
+	return &stopCollector{waypoints: geojson.NewFeatureCollection()}
+}
+
+// add adds every stop of route, in route order. Stops that cannot be
+// converted are left out and the error says which.
+func (c *stopCollector) add(route tmt.Route) error {
+	r, err := model.RouteFromAPI(route)
+	for _, s := range r.Stops {
+		feature := geojson.NewPointFeature(s.Stop.Location.Coordinates)
+		feature.SetProperty("name", s.Stop.Name)
+		feature.SetProperty("ref", s.Stop.No)
+		feature.SetProperty("highway", "bus_stop")
+		feature.SetProperty("operator", "Thane Municipal Transport")
+		feature.SetProperty("public_transport", "platform")
+		feature.SetProperty("position", strconv.Itoa(s.Sequence))
+		feature.SetProperty("route_num", r.Num)
+		feature.SetProperty("route_direction", r.Direction)
+		c.waypoints.AddFeature(feature)
+	}
+	return err
+}
+
+// addReporting adds route and prints the stops that were left out.
+func (c *stopCollector) addReporting(route tmt.Route) {
+	if err := c.add(route); err != nil {
+		fmt.Printf("Route %s %s (RouteNo %d): stops left out: %v\n", route.RouteNum, route.RouteDirection, route.RouteNo, err)
+	}
+}
+
+// routeFeatures returns the stops of route in route order as GeoJSON, the
+// content of its TMTRoutes file. Stops that cannot be converted are left
+// out; addReporting tells about them when the stops of the crawl are
+// collected.
+func routeFeatures(route tmt.Route) *geojson.FeatureCollection {
+	r, _ := model.RouteFromAPI(route)
+	routes := geojson.NewFeatureCollection()
+	for j, s := range r.Stops {
+		feature := geojson.NewPointFeature(s.Stop.Location.Coordinates)
+		feature.SetProperty("name", s.Stop.Name)
+		feature.SetProperty("ref", strconv.Itoa(s.Stop.No))
+		feature.SetProperty("position", j)
+		routes.AddFeature(feature)
 	}
+	return routes
 }
 
-func routes() {
-	var stops = make(map[int]string)
-	var ref []int
-	respRoutes, err := http.Get("http://tmtitsapi.locationtracker.com/api/getRouteMaster") //GET request to TMTU for routes data
+// routes crawls every route of getRouteMaster and writes one
+// TMTRoutes<RouteNo>-<RouteNum>.json per route plus TMTStopsThroughRoutes.json.
+// With raw set the route files hold the API response as received instead of
+// GeoJSON, which is what stops needs to rebuild the stop list later.
+//
+// cfg.API.CrawlWorkers routes are fetched at once, all paced by the rate
+// limiter of api, and each route file is written as soon as its route
+// arrives. The stops are merged in getRouteMaster order afterwards, so
+// TMTStopsThroughRoutes.json does not depend on which request finished
+// first.
+//
+// Progress is checkpointed in <out>/TMTRoutesCrawl. A crawl that was
+// interrupted or had routes fail continues from there, with the same route
+// list, unless restart is set; it is only removed once every route is done.
+//
+// A complete crawl leaves <out>/TMTCrawlManifest.json with a hash of every
+// route. The next crawl only rewrites the files of routes whose hash
+// changed, removes those of routes that are gone and prints what changed.
+func routes(ctx context.Context, api *tmt.Client, cfg *Config, raw, restart bool) error {
+	manifestPath := filepath.Join(cfg.OutDir, routeManifestFile)
+	old, err := loadRouteManifest(manifestPath)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Println(err)
+	cp := crawlCheckpoint{dir: filepath.Join(cfg.OutDir, "TMTRoutesCrawl")}
+	if restart {
+		if err := cp.remove(); err != nil {
+			return err
 		}
-	}(respRoutes.Body)
-	bodyRoutes, err := io.ReadAll(respRoutes.Body) // response body is []byte
+	}
+	resultRoutes, fetched, resumed, err := cp.routeMaster()
 	if err != nil {
-		fmt.Println("wrong here")
+		return fmt.Errorf("checkpoint: %w (use -restart to start over)", err)
 	}
-	var resultRoutes ResponseRouteMaster
-	if err := json.Unmarshal(bodyRoutes, &resultRoutes); err != nil { // Parse []byte to the go struct pointer
-		fmt.Println(err)
+	if !resumed {
+		resultRoutes, err = api.GetRouteMaster(ctx) //GET request to TMTU for routes data
+		if err != nil {
+			return err
+		}
+		if err := cp.start(resultRoutes); err != nil {
+			return err
+		}
 	}
-	waypoints := geojson.NewFeatureCollection()
-	for i := 0; i < len(resultRoutes.Data); i++ {
 
-		data := url.Values{
-			"RouteNo": {resultRoutes.Data[i].RouteNo},
-		}
-		time.Sleep(2 * time.Second)
-		fmt.Println("Restarting...")
-		fmt.Println(i)
-		respRouteNo, err := http.PostForm("http://tmtitsapi.locationtracker.com/api/getRouteDetailsNew", data) //GET request to TMTU for routes data
+	details := make([]*tmt.ResponseRouteNo, len(resultRoutes.Data))
+	entries := make([]manifestEntry, len(resultRoutes.Data))
+	changes := make([]routeChange, len(resultRoutes.Data))
+	var todo []int
+	for i, route := range resultRoutes.Data {
+		d, ok, err := cp.route(route.RouteNo)
 		if err != nil {
-			log.Fatal("hi")
+			return fmt.Errorf("checkpoint: route %s: %w (use -restart to start over)", route.RouteNo, err)
 		}
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
-				fmt.Println(err)
-			}
-		}(respRouteNo.Body)
-		bodyRouteNo, err := io.ReadAll(respRouteNo.Body) // response body is []byte
-		if err != nil {
-			fmt.Println("wrong here2")
+		if !ok {
+			todo = append(todo, i)
+			continue
 		}
-		var resultRouteNo ResponseRouteNo
-		if err := json.Unmarshal(bodyRouteNo, &resultRouteNo); err != nil { // Parse []byte to the go struct pointer
-			fmt.Println("wrong here3")
+		details[i] = d
+		if entries[i], changes[i], err = saveRoute(cfg, route, d, raw, old); err != nil {
+			return err
 		}
-		//fmt.Print(resultRouteNo)
-		routes := geojson.NewFeatureCollection()
-
-		for j := 0; j < len(resultRouteNo.Data[0].RouteDetails); j++ {
-
-			sWaypointNo, err := strconv.ParseInt(resultRouteNo.Data[0].RouteDetails[j].Waypoints.WPointNo, 10, 64)
-			if err != nil {
-				fmt.Println("wrong here15")
-			}
-			flag := 0
-			for k := 0; k < len(stops); k++ {
+	}
+	if resumed {
+		fmt.Printf("Resuming the crawl of %s: %d of %d routes done\n",
+			fetched.Format(time.RFC3339), len(resultRoutes.Data)-len(todo), len(resultRoutes.Data))
+	}
 
-				if int64(ref[k]) == sWaypointNo {
-					flag = 1
+	// A replay that runs out stops the crawl like an interrupt, without
+	// marking the routes it has no responses for as failed.
+	crawlCtx, stopCrawl := context.WithCancel(ctx)
+	defer stopCrawl()
+	var (
+		mu        sync.Mutex
+		failed    = make(map[int]error)
+		replayErr error
+		saved     int32
+		wg        sync.WaitGroup
+	)
+	jobs := make(chan int)
+	for w := 0; w < cfg.API.CrawlWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				route := resultRoutes.Data[i]
+				resultRouteNo, err := api.GetRouteDetails(crawlCtx, route.RouteNo) //POST request to TMTU for route details
+				if err == nil {
+					entries[i], changes[i], err = saveRoute(cfg, route, resultRouteNo, raw, old)
 				}
-			}
-			if flag == 0 {
-				ref = append(ref, int(sWaypointNo))
-			}
-
-			sresultRouteNoLatitude, err := strconv.ParseFloat(resultRouteNo.Data[0].RouteDetails[j].Waypoints.Latitude, 64)
-			if err != nil {
-				fmt.Println("wrong here16")
-			}
-			sresultRouteNoLongitude, err := strconv.ParseFloat(resultRouteNo.Data[0].RouteDetails[j].Waypoints.Longitude, 64)
-			if err != nil {
-				fmt.Println("wrong here1")
-			}
-			feature := geojson.NewPointFeature([]float64{sresultRouteNoLongitude, sresultRouteNoLatitude})
-			feature.SetProperty("name", resultRouteNo.Data[0].RouteDetails[j].Waypoints.WpointName)
-			feature.SetProperty("ref", resultRouteNo.Data[0].RouteDetails[j].Waypoints.WPointNo)
-			feature.SetProperty("position", j)
-			routes.AddFeature(feature)
-
-			for k := 0; k < len(ref); k++ {
-				if ref[k] == int(sWaypointNo) {
-					stops[int(sWaypointNo)] = resultRouteNo.Data[0].RouteDetails[j].Waypoints.WpointName
-					feature1 := geojson.NewPointFeature([]float64{sresultRouteNoLongitude, sresultRouteNoLatitude})
-					feature1.SetProperty("name", resultRouteNo.Data[0].RouteDetails[j].Waypoints.WpointName)
-					feature1.SetProperty("ref", sWaypointNo)
-					feature1.SetProperty("highway", "bus_stop")
-					feature1.SetProperty("operator", "Thane Municipal Transport")
-					feature1.SetProperty("public_transport", "platform")
-					feature1.SetProperty("position", resultRouteNo.Data[0].RouteDetails[j].SequenceNo)
-					feature1.SetProperty("route_num", resultRouteNo.Data[0].RouteNum)
-					feature1.SetProperty("route_direction", resultRouteNo.Data[0].RouteDirection)
-					waypoints.AddFeature(feature1)
+				if err == nil {
+					err = cp.done(route.RouteNo, resultRouteNo)
+				}
+				if err != nil {
+					if crawlCtx.Err() != nil {
+						return
+					}
+					if replayEnded(err) {
+						mu.Lock()
+						replayErr = err
+						mu.Unlock()
+						stopCrawl()
+						return
+					}
+					fmt.Printf("Route %s (%s) failed: %v\n", route.RouteNum, route.RouteNo, err)
+					mu.Lock()
+					failed[i] = err
+					mu.Unlock()
+					continue
 				}
+				details[i] = resultRouteNo
+				fmt.Printf("Route %s (%s) %s, %d of %d\n", route.RouteNum, route.RouteNo,
+					[...]string{"unchanged", "added", "modified"}[changes[i]], atomic.AddInt32(&saved, 1), len(todo))
 			}
+		}()
+	}
+feed:
+	for _, i := range todo {
+		select {
+		case jobs <- i:
+		case <-crawlCtx.Done():
+			break feed
 		}
+	}
+	close(jobs)
+	wg.Wait()
 
-		rawJSON1, err := routes.MarshalJSON()
-		if err != nil {
-			fmt.Printf("error: %v", err)
-			return
+	if ctx.Err() != nil {
+		fmt.Printf("Interrupted after %d of %d routes, run routes again to resume\n", saved, len(todo))
+		return nil
+	}
+	if replayErr != nil {
+		return fmt.Errorf("%v: the replay is over after %d of %d routes", replayErr, saved, len(todo))
+	}
+	if len(failed) > 0 {
+		fmt.Printf("%d of %d routes failed, %s not written:\n", len(failed), len(resultRoutes.Data), "TMTStopsThroughRoutes.json")
+		for _, i := range todo { // getRouteMaster order
+			if err, ok := failed[i]; ok {
+				fmt.Printf("  %s (RouteNo %s): %v\n", resultRoutes.Data[i].RouteNum, resultRoutes.Data[i].RouteNo, err)
+			}
 		}
+		return fmt.Errorf("%d routes failed, run routes again to retry them", len(failed))
+	}
 
-		fn := fmt.Sprintf("output/TMTRoutes%s-%s.json", resultRoutes.Data[i].RouteNo, resultRoutes.Data[i].RouteNum)
-		err = os.WriteFile(fn, rawJSON1, 0644)
-		if err != nil {
-			log.Fatal(err)
-		}
+	collector := newStopCollector()
+	for _, resultRouteNo := range details {
+		collector.addReporting(resultRouteNo.Data[0])
 	}
-	rawJSON, err := waypoints.MarshalJSON()
+	rawJSON, err := collector.waypoints.MarshalJSON()
 	if err != nil {
-		fmt.Printf("error: %v", err)
-		return
+		return err
 	}
 
 	//Saves the geojson file for bus stops to the current directory
 	//fmt.Printf("%s", string(rawJSON))
-	err = os.WriteFile("output/TMTStopsThroughRoutes.json", rawJSON, 0644)
-	if err != nil {
-		log.Fatal(err)
+	if err := writeIfChanged(filepath.Join(cfg.OutDir, "TMTStopsThroughRoutes.json"), rawJSON); err != nil {
+		return err
 	}
-}
 
-func routes_unmodified() {
-	var stops = make(map[int]string)
-	var ref []int
-	respRoutes, err := http.Get("http://tmtitsapi.locationtracker.com/api/getRouteMaster") //GET request to TMTU for routes data
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Println(err)
+	m := &routeManifest{Updated: time.Now(), Raw: raw, Routes: make(map[string]manifestEntry)}
+	var added, modified []string
+	unchanged := 0
+	for i, route := range resultRoutes.Data {
+		m.Routes[route.RouteNo] = entries[i]
+		switch changes[i] {
+		case routeAdded:
+			added = append(added, route.RouteNo)
+		case routeModified:
+			modified = append(modified, route.RouteNo)
+		default:
+			unchanged++
 		}
-	}(respRoutes.Body)
-	bodyRoutes, err := io.ReadAll(respRoutes.Body) // response body is []byte
+	}
+	removed, err := removeStaleRouteFiles(cfg, old, m)
 	if err != nil {
-		fmt.Println("wrong here")
+		return err
 	}
-	var resultRoutes ResponseRouteMaster
-	if err := json.Unmarshal(bodyRoutes, &resultRoutes); err != nil { // Parse []byte to the go struct pointer
-		fmt.Println(err)
+	if err := m.save(manifestPath); err != nil {
+		return err
 	}
-	waypoints := geojson.NewFeatureCollection()
-	for i := 0; i < len(resultRoutes.Data); i++ {
-
-		data := url.Values{
-			"RouteNo": {resultRoutes.Data[i].RouteNo},
-		}
-		time.Sleep(2 * time.Second)
-		fmt.Println("Restarting...")
-		fmt.Println(i)
-		respRouteNo, err := http.PostForm("http://tmtitsapi.locationtracker.com/api/getRouteDetailsNew", data) //GET request to TMTU for routes data
-		if err != nil {
-			log.Fatal("hi")
-		}
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
-				fmt.Println(err)
-			}
-		}(respRouteNo.Body)
-		bodyRouteNo, err := io.ReadAll(respRouteNo.Body) // response body is []byte
-		if err != nil {
-			fmt.Println("wrong here2")
-		}
-		var resultRouteNo ResponseRouteNo
-		if err := json.Unmarshal(bodyRouteNo, &resultRouteNo); err != nil { // Parse []byte to the go struct pointer
-			fmt.Println("wrong here3")
-		}
-		//fmt.Print(resultRouteNo)
-		routes := geojson.NewFeatureCollection()
-
-		for j := 0; j < len(resultRouteNo.Data[0].RouteDetails); j++ {
-
-			sWaypointNo, err := strconv.ParseInt(resultRouteNo.Data[0].RouteDetails[j].Waypoints.WPointNo, 10, 64)
-			if err != nil {
-				fmt.Println("wrong here15")
-			}
-			flag := 0
-			for k := 0; k < len(stops); k++ {
-
-				if int64(ref[k]) == sWaypointNo {
-					flag = 1
-				}
-			}
-			if flag == 0 {
-				ref = append(ref, int(sWaypointNo))
-			}
-
-			sresultRouteNoLatitude, err := strconv.ParseFloat(resultRouteNo.Data[0].RouteDetails[j].Waypoints.Latitude, 64)
-			if err != nil {
-				fmt.Println("wrong here16")
-			}
-			sresultRouteNoLongitude, err := strconv.ParseFloat(resultRouteNo.Data[0].RouteDetails[j].Waypoints.Longitude, 64)
-			if err != nil {
-				fmt.Println("wrong here1")
-			}
-			feature := geojson.NewPointFeature([]float64{sresultRouteNoLongitude, sresultRouteNoLatitude})
-			feature.SetProperty("name", resultRouteNo.Data[0].RouteDetails[j].Waypoints.WpointName)
-			feature.SetProperty("ref", resultRouteNo.Data[0].RouteDetails[j].Waypoints.WPointNo)
-			feature.SetProperty("position", j)
-			routes.AddFeature(feature)
-
-			for k := 0; k < len(ref); k++ {
-				if ref[k] == int(sWaypointNo) {
-					stops[int(sWaypointNo)] = resultRouteNo.Data[0].RouteDetails[j].Waypoints.WpointName
-					feature1 := geojson.NewPointFeature([]float64{sresultRouteNoLongitude, sresultRouteNoLatitude})
-					feature1.SetProperty("name", resultRouteNo.Data[0].RouteDetails[j].Waypoints.WpointName)
-					feature1.SetProperty("ref", sWaypointNo)
-					feature1.SetProperty("highway", "bus_stop")
-					feature1.SetProperty("operator", "Thane Municipal Transport")
-					feature1.SetProperty("public_transport", "platform")
-					feature1.SetProperty("position", resultRouteNo.Data[0].RouteDetails[j].SequenceNo)
-					feature1.SetProperty("route_num", resultRouteNo.Data[0].RouteNum)
-					feature1.SetProperty("route_direction", resultRouteNo.Data[0].RouteDirection)
-					waypoints.AddFeature(feature1)
-				}
-			}
-		}
+	fmt.Println(routeChangeSummary(added, removed, modified, unchanged))
+	return cp.remove()
+}
 
-		fn := fmt.Sprintf("output/TMTRoutes%s-%s.json", resultRoutes.Data[i].RouteNo, resultRoutes.Data[i].RouteNum)
-		err = os.WriteFile(fn, bodyRouteNo, 0644)
+// writeRouteFile writes the TMTRoutes file of route, as received if raw is
+// set and as GeoJSON otherwise.
+func writeRouteFile(cfg *Config, route tmt.RouteMaster, details *tmt.ResponseRouteNo, raw bool) error {
+	rawJSON1 := details.Raw
+	if !raw {
+		var err error
+		rawJSON1, err = routeFeatures(details.Data[0]).MarshalJSON()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
-	rawJSON, err := waypoints.MarshalJSON()
-	if err != nil {
-		fmt.Printf("error: %v", err)
-		return
-	}
-
-	//Saves the geojson file for bus stops to the current directory
-	//fmt.Printf("%s", string(rawJSON))
-	err = os.WriteFile("output/TMTStopsThroughRoutes.json", rawJSON, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return os.WriteFile(filepath.Join(cfg.OutDir, routeFileName(route)), rawJSON1, 0644)
 }
 
-func buslocations() {
+// buslocations polls getLastTrackingData forever and stores the first and
+// every changed position of each bus in the store selected by cfg.
+func buslocations(ctx context.Context, api *tmt.Client, cfg *Config) error {
 
 	start := time.Now()
-	fmt.Println("--------WARNING THIS WILL RUN INDEFINITELY--------")
-	fmt.Println("-------------TO EXIT STOP THE PROGRAM-------------")
+	fmt.Println("-----TRACKING UNTIL INTERRUPTED (Ctrl+C or SIGTERM)-----")
 	fmt.Printf("Started Bus Location Tracking At:%s\n", start.String())
 	i := 1
 
-	uri := "mongodb://localhost:27017" //monogodb Connection String
-
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(uri))
+	loc, err := cfg.location()
 	if err != nil {
-		panic(err)
+		return err
 	}
-
+	spool, err := store.OpenSpool(cfg.Track.Spool)
+	if err != nil {
+		return err
+	}
+	if n := spool.Stats().Positions; n > 0 {
+		fmt.Printf("%d positions from an earlier run are waiting in %s\n", n, cfg.Track.Spool)
+	}
+	for _, name := range spool.Quarantined() {
+		fmt.Printf("Spool: %s could not be read, moved aside as %s.bad\n", name, name)
+	}
+	var st store.Store // nil until the store can be opened
 	defer func() {
-		if err := client.Disconnect(context.TODO()); err != nil {
+		if st == nil {
+			return
+		}
+		closeCtx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := st.Close(closeCtx); err != nil {
 			fmt.Println(err)
 		}
 	}()
-	var previousBusLocations ResponseBusLocations // Declare the variable
-
-	for {
-		noOfAddedPositions := 1
-		fmt.Printf("Running: %d(s) times, time since start:%s", i, time.Since(start).String())
-		respBusLocations, err := http.Get("http://tmtitsapi.locationtracker.com/api/getLastTrackingData") //GET request to TMTU for BusLocations data
-		if err != nil {
-			log.Default()
+	var raw *archive.Writer
+	if cfg.Archive.Dir != "" {
+		if raw, err = archive.NewWriter(cfg.Archive.Dir); err != nil {
+			return err
 		}
-		defer func(Body io.ReadCloser) {
-			err := Body.Close()
-			if err != nil {
+		defer func() {
+			if err := raw.Close(); err != nil {
 				fmt.Println(err)
 			}
-		}(respBusLocations.Body)
-
-		bodyBusLocations, err := io.ReadAll(respBusLocations.Body) // response body is []byte
-		if err != nil {
+		}()
+	}
+	changes := newChangeDetector()
+	quality := newQualityReport()
+	lastReport := time.Now()
+	report := func() {
+		fmt.Println(quality.summary())
+		if err := quality.write(filepath.Join(cfg.OutDir, "TMTQuality.json")); err != nil {
+			fmt.Println(err)
+		}
+		q := spool.Stats()
+		fmt.Printf("Spool: %d positions waiting, at most %d since start\n", q.Positions, q.Peak)
+		if err := writeSpoolStats(filepath.Join(cfg.OutDir, "TMTSpool.json"), q); err != nil {
 			fmt.Println(err)
 		}
+		lastReport = time.Now()
+	}
 
-		var busLocations ResponseBusLocations
+	// A signal cancels ctx, which only ends the loop between cycles or
+	// interrupts the request; a cycle that got its data always stores it.
+	for ctx.Err() == nil {
+		fmt.Printf("Running: %d(s) times, time since start:%s", i, time.Since(start).String())
+		busLocations, err := api.GetLastTrackingData(ctx) //GET request to TMTU for BusLocations data
 		if err != nil {
-			log.Fatal(err)
+			if ctx.Err() != nil {
+				break
+			}
+			if replayEnded(err) {
+				fmt.Printf("\n%v\nThe replay is over\n", err)
+				break
+			}
+			fmt.Printf("\n%v\nWaiting for %s...\n", err, cfg.Track.Interval)
+			sleep(ctx, cfg.Track.Interval)
+			i++
+			continue
+		}
+		if raw != nil {
+			if err := raw.Write(time.Now(), busLocations.Raw); err != nil {
+				fmt.Printf("\nArchive: %v", err)
+			}
 		}
 
-		json.Unmarshal(bodyBusLocations, &busLocations)
-
-		if previousBusLocations.Data == nil {
-			previousBusLocations = busLocations
+		firstRun := len(changes.lastSeen) == 0
+		changed := changes.changed(busLocations.Data)
+		if firstRun {
 			fmt.Print("\n")
-			fmt.Printf("First Run, %d items added\n", len(busLocations.Data))
+			fmt.Printf("First Run, %d items added\n", len(changed))
 		}
 
-		for _, prevLocation := range previousBusLocations.Data {
-			for j, location := range busLocations.Data {
-				if prevLocation.VehID == location.VehID && prevLocation.LastTrackdt != location.LastTrackdt {
-					// Found an item with different 'LastTrackDt' field
-					coll := client.Database("TMTU").Collection(busLocations.Data[j].VehID)
-
-					lastTrackdtTime, _ := time.Parse("2006-01-02 15:04:05", busLocations.Data[j].LastTrackdt)
-					lastTrackdtBson := primitive.NewDateTimeFromTime(lastTrackdtTime)
-					prevTrackdtTime, _ := time.Parse("2006-01-02 15:04:05", busLocations.Data[j].PrevTrackDt)
-					prevTrackdtBson := primitive.NewDateTimeFromTime(prevTrackdtTime)
-					LastNCSentDateTime, _ := time.Parse("2006-01-02 15:04:05", busLocations.Data[j].LastNCSentDate)
-					LastNCSentDateBson := primitive.NewDateTimeFromTime(LastNCSentDateTime)
-					DispatchDateTime, _ := time.Parse("2006-01-02 15:04:05", busLocations.Data[j].DispatchDateTime)
-					DispatchDateBson := primitive.NewDateTimeFromTime(DispatchDateTime)
-
-					iVehID, _ := strconv.Atoi(busLocations.Data[j].VehID)
-					iCmpID, _ := strconv.Atoi(busLocations.Data[j].CmpID)
-					iToken, _ := strconv.Atoi(busLocations.Data[j].Token)
-					iRouteNo, _ := strconv.Atoi(busLocations.Data[j].RouteNo)
-					iWaybillNo, _ := strconv.Atoi(busLocations.Data[j].WaybillNo)
-
-					fbusLocationsLatitude, _ := strconv.ParseFloat(busLocations.Data[j].Latitude, 64)
-					fbusLocationsLongitude, _ := strconv.ParseFloat(busLocations.Data[j].Longitude, 64)
-
-					fFuel, _ := strconv.ParseFloat(busLocations.Data[j].Fuel, 64)
-					fOdometer, _ := strconv.ParseFloat(busLocations.Data[j].Odometer, 64)
-					fDistance, _ := strconv.ParseFloat(busLocations.Data[j].Distance, 64)
-					fETATime, _ := strconv.ParseFloat(busLocations.Data[j].ETATime, 64)
-					fETAOldTime, _ := strconv.ParseFloat(busLocations.Data[j].ETAOldTime, 64)
-					fETATime1, _ := strconv.ParseFloat(busLocations.Data[j].ETATime1, 64)
-					fETAOldTime1, _ := strconv.ParseFloat(busLocations.Data[j].ETAOldTime1, 64)
-					fAvgspeed, _ := strconv.ParseFloat(busLocations.Data[j].Avgspeed, 64)
-					fSpeed, _ := strconv.ParseFloat(busLocations.Data[j].Speed, 64)
-
-					var bIgnition bool
-					var bAC bool
-					var bDI4 bool
-					var bAUX1 bool
-					if busLocations.Data[j].Ignition == "ON" {
-						bIgnition, _ = strconv.ParseBool("true")
-					}
-
-					if busLocations.Data[j].AUX1 == "ON" {
-						bAUX1, _ = strconv.ParseBool("true")
-					}
-					if busLocations.Data[j].DI4 == "ON" {
-						bDI4, _ = strconv.ParseBool("true")
-					}
-					if busLocations.Data[j].AC == "ON" {
-						bAC, _ = strconv.ParseBool("true")
-					}
-
-					bRouteflag, _ := strconv.ParseBool(busLocations.Data[j].Routeflag)
-
-					if busLocations.Data[j].CSent == "0" {
-						busLocations.Data[j].CSent = ""
-					}
-					if busLocations.Data[j].NCSent == "0" {
-						busLocations.Data[j].NCSent = ""
-					}
-					if busLocations.Data[j].Temparature == "0" {
-						busLocations.Data[j].Temparature = ""
-					}
-
-					location := Location{
-						Type:        "Point",
-						Coordinates: []float64{fbusLocationsLongitude, fbusLocationsLatitude},
-					}
-					bus := Data{
-						IdxTrackidPk:     busLocations.Data[j].IdxTrackidPk,
-						VehID:            iVehID,
-						CmpID:            iCmpID,
-						LastTrackdt:      lastTrackdtBson,
-						NCSent:           busLocations.Data[j].NCSent,
-						CSent:            busLocations.Data[j].CSent,
-						PrevTrackDt:      prevTrackdtBson,
-						LastNCSentDate:   LastNCSentDateBson,
-						City:             busLocations.Data[j].City,
-						Speed:            fSpeed,
-						ImagePath:        busLocations.Data[j].ImagePath,
-						AC:               bAC,
-						Ignition:         bIgnition,
-						AUX1:             bAUX1,
-						DI4:              bDI4,
-						Fuel:             fFuel,
-						Temparature:      busLocations.Data[j].Temparature,
-						WPointNo:         busLocations.Data[j].WPointNo,
-						Odometer:         fOdometer,
-						Distance:         fDistance,
-						ETATime:          fETATime,
-						ETARoute:         busLocations.Data[j].ETARoute,
-						ETAOldTime:       fETAOldTime,
-						Routeflag:        bRouteflag,
-						ETARouteName:     busLocations.Data[j].ETARouteName,
-						DirectionFrom:    busLocations.Data[j].DirectionFrom,
-						DirectionTo:      busLocations.Data[j].DirectionTo,
-						DispatchDateTime: DispatchDateBson,
-						ETATime1:         fETATime1,
-						ETAOldTime1:      fETAOldTime1,
-						Routeflag1:       busLocations.Data[j].Routeflag1,
-						RouteNo:          iRouteNo,
-						WaybillNo:        iWaybillNo,
-						Lastwaypointid:   busLocations.Data[j].Lastwaypointid,
-						Token:            iToken,
-						Avgspeed:         fAvgspeed,
-						VehNo:            busLocations.Data[j].GetVehicle.VehNo,
-						Location:         location,
-					}
-
-					coll.InsertOne(context.TODO(), bus)
-					fmt.Print("\n")
-					
-					noOfAddedPositions++
-				}
+		positions := convertPositions(changed, loc, quality)
+		if err := spool.Push(positions); err != nil {
+			fmt.Printf("\nSpool: %v", err)
+		}
+		if st == nil {
+			if st, err = openStoreTimeout(cfg); err != nil {
+				fmt.Printf("\nStore unavailable: %v", err)
 			}
 		}
-
-
-		previousBusLocations = busLocations
-
-		respLimitRemaining := respBusLocations.Header.Get("X-RateLimit-Remaining")
-		respLimitRemainingint, err := strconv.ParseInt(respLimitRemaining, 10, 64)
-		if err != nil {
-			fmt.Println(err)
+		var written store.Result
+		if st != nil {
+			wctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			written, err = spool.Drain(wctx, st, spoolDrainBatches)
+			cancel()
+			if err != nil {
+				fmt.Printf("\n%v", err)
+			}
+			if written.Duplicates > 0 {
+				fmt.Printf("\n%d positions were already stored", written.Duplicates)
+			}
+		}
+		if q := spool.Stats(); q.Positions > 0 {
+			fmt.Printf("\n%d positions in %d batches waiting for the store, oldest from %s",
+				q.Positions, q.Batches, q.Oldest.Format(time.RFC3339))
 		}
 
-		for {
-			if 5 < respLimitRemainingint {
-				break
-			} else {
-				time.Sleep(10 * time.Second)
-				fmt.Print("Waiting for 5 seconds for the API limit to reset\n")
-			}
+		if written.Dropped > 0 {
+			fmt.Printf("\nSaved Bus Location data for %d buses, %d positions refused by the store, at %s \n",
+				written.Written, written.Dropped, time.Now())
+		} else {
+			fmt.Printf("\nSaved Bus Location data for %d buses at %s \n", written.Written, time.Now())
+		}
+		if time.Since(lastReport) >= cfg.Track.ReportInterval {
+			report()
 		}
-		fmt.Printf("\nSaved Bus Location data for %d buses at %s \n", noOfAddedPositions, time.Now())
-		//fmt.Printf("API Limit Remaining: %s \n", respLimitRemaining)
-		fmt.Println("Waiting for 7secs...")
-		time.Sleep(7 * time.Second)
+		if api.Limiter != nil {
+			fmt.Printf("API: %s\n", api.Limiter.State())
+		}
+		fmt.Printf("Waiting for %s...\n", cfg.Track.Interval)
+		sleep(ctx, cfg.Track.Interval)
 		i++
 	}
+
+	fmt.Printf("\nStopping after %d cycles\n", i-1)
+	report()
+	if n := spool.Stats().Positions; n > 0 && cfg.Track.Spool != "" {
+		fmt.Printf("%d positions stay in %s for the next run\n", n, cfg.Track.Spool)
+	} else if n > 0 {
+		fmt.Printf("%d positions were not stored, set track.spool to keep them\n", n)
+	}
+	return nil
+}
+
+// sleep waits for d or until ctx is cancelled and reports whether it waited
+// the whole time.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"TMTU/mock"
+	"TMTU/store"
+	"TMTU/tmt"
+)
+
+// newTestAPI returns a configuration writing to a temporary directory and a
+// client for a mock server with the built-in fixtures.
+func newTestAPI(t *testing.T) (*Config, *tmt.Client) {
+	t.Helper()
+	srv, err := mock.NewServer(mock.Fixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Limit = 10000 // requests are spread over the window, keep them close together
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.OutDir = dir
+	cfg.API.BaseURL = ts.URL + "/api"
+	cfg.API.CrawlDelay = 0
+	cfg.Store.Backend = "jsonl"
+	cfg.Store.JSONL = filepath.Join(dir, "positions")
+	cfg.Archive.Dir = ""
+	cfg.Track.Spool = ""
+	cfg.Track.Interval = 10 * time.Millisecond
+	api, err := newAPI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, api
+}
+
+// readFeatures returns the features of a GeoJSON file.
+func readFeatures(t *testing.T, name string) []map[string]interface{} {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Features []map[string]interface{} `json:"features"`
+	}
+	if err := json.Unmarshal(b, &fc); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return fc.Features
+}
+
+func TestWaypoints(t *testing.T) {
+	cfg, api := newTestAPI(t)
+	if err := waypoints(context.Background(), api, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	features := readFeatures(t, filepath.Join(cfg.OutDir, "TMTStopsDirect.json"))
+	if len(features) == 0 {
+		t.Fatal("no stops written")
+	}
+	refs := make(map[interface{}]bool)
+	for _, f := range features {
+		ref := f["properties"].(map[string]interface{})["ref"]
+		if refs[ref] {
+			t.Errorf("stop %v written twice", ref)
+		}
+		refs[ref] = true
+	}
+}
+
+func TestWaypointsAPIError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"error","messages":"Invalid token","data":[]}`)
+	}))
+	defer ts.Close()
+	cfg, _ := newTestAPI(t)
+	cfg.API.BaseURL = ts.URL + "/api"
+	api, err := newAPI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = waypoints(context.Background(), api, cfg)
+	var e *tmt.APIError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want the APIError", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutDir, "TMTStopsDirect.json")); !os.IsNotExist(err) {
+		t.Errorf("stops written after an API error: %v", err)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	cfg, api := newTestAPI(t)
+	cfg.API.CrawlWorkers = 2
+	if err := routes(context.Background(), api, cfg, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"TMTRoutes1-1.json", "TMTRoutes2-1.json", "TMTRoutes3-12.json", routeManifestFile} {
+		if _, err := os.Stat(filepath.Join(cfg.OutDir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cfg.OutDir, "TMTRoutesCrawl")); !os.IsNotExist(err) {
+		t.Errorf("checkpoint left after a complete crawl: %v", err)
+	}
+	stopsPath := filepath.Join(cfg.OutDir, "TMTStopsThroughRoutes.json")
+	crawled, err := os.ReadFile(stopsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readFeatures(t, stopsPath)) == 0 {
+		t.Fatal("no stops written")
+	}
+
+	// rebuild-stops must come to the same result from the raw route files.
+	if err := os.Remove(stopsPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := stops(cfg); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := os.ReadFile(stopsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rebuilt) != string(crawled) {
+		t.Error("rebuild-stops differs from the crawl")
+	}
+
+	// A second crawl finds every route unchanged.
+	m, err := loadRouteManifest(filepath.Join(cfg.OutDir, routeManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := routes(context.Background(), api, cfg, true, false); err != nil {
+		t.Fatal(err)
+	}
+	again, err := loadRouteManifest(filepath.Join(cfg.OutDir, routeManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Routes) != 3 {
+		t.Fatalf("manifest has %d routes, want 3", len(again.Routes))
+	}
+	for no, e := range m.Routes {
+		if again.Routes[no] != e {
+			t.Errorf("route %s changed between two crawls of the same data", no)
+		}
+	}
+}
+
+func TestRebuildStopsError(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.OutDir = t.TempDir()
+	// A directory in the way of the output file.
+	if err := os.Mkdir(filepath.Join(cfg.OutDir, "TMTStopsThroughRoutes.json"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := stops(cfg); err == nil {
+		t.Error("no error when TMTStopsThroughRoutes.json cannot be written")
+	}
+}
+
+func TestBuslocations(t *testing.T) {
+	cfg, api := newTestAPI(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := buslocations(ctx, api, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := store.OpenJSONL(cfg.Store.JSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close(context.Background())
+	ps, err := st.Positions(context.Background(), 0, time.Time{}, time.Now().AddDate(10, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) == 0 {
+		t.Fatal("no positions stored")
+	}
+	seen := make(map[[2]int64]bool)
+	for _, p := range ps {
+		k := [2]int64{int64(p.ID), p.LastTrackdt.Unix()}
+		if seen[k] {
+			t.Errorf("position of bus %d at %s stored twice", p.ID, p.LastTrackdt)
+		}
+		seen[k] = true
+	}
+}
+
+func TestReplayEnds(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	cfg.API.Record = filepath.Join(cfg.OutDir, "recording")
+	api, err := newAPI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := buslocations(ctx, api, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.API.Record, cfg.API.Replay = "", cfg.API.Record
+	cfg.Store.JSONL = filepath.Join(cfg.OutDir, "replayed")
+	if api, err = newAPI(cfg); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := buslocations(ctx, api, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("track did not stop at the end of the replay")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("the replay took %s, the last request was retried", d)
+	}
+}
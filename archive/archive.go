@@ -0,0 +1,185 @@
+// Package archive keeps the raw getLastTrackingData responses in hourly
+// gzip-compressed NDJSON files, so that they can be reprocessed later.
+//
+// Each line of a file is a Record. Files are named
+// tracking-YYYYMMDD-HH.ndjson.gz after the UTC hour their records were
+// fetched in; a process starting in an hour that already has a file writes
+// tracking-YYYYMMDD-HH-<n>.ndjson.gz instead.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is one archived response.
+type Record struct {
+	FetchedAt time.Time       `json:"fetched_at"`
+	Body      json.RawMessage `json:"body"` // as received but compacted to one line; a JSON string if it was not valid JSON
+}
+
+// Writer appends Records to the file of the current hour.
+type Writer struct {
+	dir string
+
+	hour time.Time // of the open file
+	file *os.File
+	gz   *gzip.Writer
+}
+
+// NewWriter returns a Writer storing files in dir, which is created if
+// needed.
+func NewWriter(dir string) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &Writer{dir: dir}, nil
+}
+
+// FileName returns the name of the first file for records fetched at t.
+func FileName(t time.Time) string {
+	return fileName(t, 0)
+}
+
+// fileName returns the name of the n-th file of the hour of t.
+func fileName(t time.Time, n int) string {
+	if n == 0 {
+		return t.UTC().Format("tracking-20060102-15.ndjson.gz")
+	}
+	return t.UTC().Format("tracking-20060102-15-") + strconv.Itoa(n) + ".ndjson.gz"
+}
+
+// DamagedError reports an archive file that could not be read to its end.
+// ReadFile returns it together with the records before the damage, which
+// are sound.
+type DamagedError struct {
+	Name    string
+	Records int // read before the damage
+	Err     error
+}
+
+func (e *DamagedError) Error() string {
+	return fmt.Sprintf("archive: %s: damaged after %d records: %v", e.Name, e.Records, e.Err)
+}
+
+func (e *DamagedError) Unwrap() error { return e.Err }
+
+// Write archives body, fetched at t. The record is flushed to the file
+// before Write returns so that a crash loses at most the current record.
+func (w *Writer) Write(t time.Time, body []byte) error {
+	hour := t.UTC().Truncate(time.Hour)
+	if w.file == nil || !hour.Equal(w.hour) {
+		if err := w.rotate(hour); err != nil {
+			return err
+		}
+	}
+
+	rec := Record{FetchedAt: t, Body: body}
+	if !json.Valid(body) {
+		rec.Body, _ = json.Marshal(string(body))
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := w.gz.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return w.gz.Flush()
+}
+
+// rotate closes the current file and creates a new one for hour. Existing
+// files are never appended to: one left by a process that was killed ends
+// in an unfinished gzip member, and anything written after it could not be
+// decompressed.
+func (w *Writer) rotate(hour time.Time) error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	for n := 0; ; n++ {
+		f, err := os.OpenFile(filepath.Join(w.dir, fileName(hour, n)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		w.file, w.gz, w.hour = f, gzip.NewWriter(f), hour
+		return nil
+	}
+}
+
+// Close finishes the current file.
+func (w *Writer) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	gzErr := w.gz.Close()
+	err := w.file.Close()
+	w.file, w.gz = nil, nil
+	if gzErr != nil {
+		return fmt.Errorf("archive: %w", gzErr)
+	}
+	return err
+}
+
+// ReadFile returns the records of an archive file, gzip-compressed or not.
+// A file cut short, e.g. by a crash while writing, yields the records before
+// the cut. If the file is damaged, e.g. by an older version appending to a
+// file left unfinished, the records before the damage are returned with a
+// *DamagedError.
+func ReadFile(name string) ([]Record, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err == io.EOF {
+			return nil, nil // created but nothing written
+		}
+		if err != nil {
+			return nil, &DamagedError{Name: name, Err: err}
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var recs []Record
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			if errors.Is(sc.Err(), io.ErrUnexpectedEOF) {
+				break // last line cut short
+			}
+			if sc.Err() != nil {
+				break // a damaged member, reported below
+			}
+			return recs, &DamagedError{Name: name, Records: len(recs), Err: fmt.Errorf("record %d: %w", len(recs)+1, err)}
+		}
+		recs = append(recs, rec)
+	}
+	if err := sc.Err(); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return recs, &DamagedError{Name: name, Records: len(recs), Err: err}
+	}
+	return recs, nil
+}
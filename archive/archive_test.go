@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var hour = time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+
+func bodies(recs []Record) []string {
+	var b []string
+	for _, r := range recs {
+		b = append(b, string(r.Body))
+	}
+	return b
+}
+
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, body := range []string{`{"data":[1]}`, `{"data": [2]}`, "Too Many Attempts."} {
+		if err := w.Write(hour.Add(time.Duration(i)*time.Minute), []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write(hour.Add(time.Hour), []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := ReadFile(filepath.Join(dir, FileName(hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`{"data":[1]}`, `{"data":[2]}`, `"Too Many Attempts."`}; !reflect.DeepEqual(bodies(recs), want) {
+		t.Errorf("records %v, want %v", bodies(recs), want)
+	}
+	if !recs[1].FetchedAt.Equal(hour.Add(time.Minute)) {
+		t.Errorf("fetched at %s", recs[1].FetchedAt)
+	}
+	if recs, err := ReadFile(filepath.Join(dir, FileName(hour.Add(time.Hour)))); err != nil || len(recs) != 1 {
+		t.Errorf("next hour: %d records, %v", len(recs), err)
+	}
+}
+
+// TestRestartAfterCrash checks that a writer started after one that was
+// killed in the same hour loses nothing.
+func TestRestartAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	killed, err := NewWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	killed.Write(hour, []byte(`1`))
+	killed.Write(hour.Add(time.Minute), []byte(`2`))
+	// no Close: the gzip member stays unfinished
+
+	w, err := NewWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(hour.Add(2*time.Minute), []byte(`3`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, name := range []string{"tracking-20231120-10.ndjson.gz", "tracking-20231120-10-1.ndjson.gz"} {
+		recs, err := ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		got = append(got, bodies(recs)...)
+	}
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records %v, want %v", got, want)
+	}
+}
+
+// TestReadDamaged reads a file written the way older versions did after a
+// crash: a new gzip member appended to an unfinished one.
+func TestReadDamaged(t *testing.T) {
+	name := filepath.Join(t.TempDir(), FileName(hour))
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(`{"fetched_at":"2023-11-20T10:00:00Z","body":1}` + "\n" + `{"fetched_at":"2023-11-20T10:01:00Z","body":2}` + "\n"))
+	gz.Flush()
+	gz = gzip.NewWriter(f)
+	gz.Write([]byte(`{"fetched_at":"2023-11-20T10:02:00Z","body":3}` + "\n"))
+	gz.Close()
+	f.Close()
+
+	recs, err := ReadFile(name)
+	var damaged *DamagedError
+	if !errors.As(err, &damaged) || damaged.Records != 2 {
+		t.Errorf("got %v, want a DamagedError after 2 records", err)
+	}
+	if want := []string{"1", "2"}; !reflect.DeepEqual(bodies(recs), want) {
+		t.Errorf("records %v, want %v", bodies(recs), want)
+	}
+}
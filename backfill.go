@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"TMTU/archive"
+	"TMTU/cassette"
+	"TMTU/model"
+	"TMTU/store"
+	"TMTU/tmt"
+)
+
+// backfillBatch is the number of positions sent to the store at once.
+const backfillBatch = 1000
+
+// snapshot is a getLastTrackingData body and the time it was fetched.
+type snapshot struct {
+	fetchedAt time.Time
+	body      []byte
+	path      string // of the file it was read from
+}
+
+// snapshotFile is a file holding snapshots, and when its first one was
+// fetched. For archive files that is the start of their hour.
+type snapshotFile struct {
+	path  string
+	first time.Time
+}
+
+// snapshotQueue merges the snapshots of files, which may overlap, e.g. an
+// archive hour and -record files of the same time, into the order they
+// were fetched. A file is only read once the snapshots fetched before its
+// first one have been taken, so that not all files are held in memory.
+type snapshotQueue struct {
+	files   []snapshotFile // not read yet, ordered by first
+	pending []snapshot     // read, oldest first
+	read    func(snapshotFile) []snapshot
+}
+
+// next returns the snapshot fetched first of those not taken yet. ok is
+// false when there are none left.
+func (q *snapshotQueue) next() (s snapshot, ok bool) {
+	for len(q.files) > 0 && (len(q.pending) == 0 || !q.files[0].first.After(q.pending[0].fetchedAt)) {
+		q.pending = append(q.pending, q.read(q.files[0])...)
+		q.files = q.files[1:]
+		sort.SliceStable(q.pending, func(i, j int) bool { return q.pending[i].fetchedAt.Before(q.pending[j].fetchedAt) })
+	}
+	if len(q.pending) == 0 {
+		return snapshot{}, false
+	}
+	s = q.pending[0]
+	q.pending = q.pending[1:]
+	return s, true
+}
+
+// backfill feeds the snapshots found in paths through the same change
+// detection and conversion as track and writes the result to the store.
+// Snapshots are processed in the order they were fetched, across files. A
+// file that cannot be read completely is reported and the snapshots read from it
+// are used. When ctx is cancelled the positions converted so far are still
+// written.
+func backfill(ctx context.Context, cfg *Config, paths []string) error {
+	if len(paths) == 0 {
+		if cfg.Archive.Dir == "" {
+			return fmt.Errorf("no files given and archive.dir is not set")
+		}
+		paths = []string{cfg.Archive.Dir}
+	}
+	loc, err := cfg.location()
+	if err != nil {
+		return err
+	}
+	files, err := findSnapshotFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no snapshot files found in %s", strings.Join(paths, ", "))
+	}
+	if cfg.Store.Backend == "mongo" && cfg.Mongo.Layout == store.LayoutTimeseries {
+		fmt.Println("Warning: time-series collections cannot reject duplicates, positions stored before will be stored again")
+	}
+
+	// Writes are not tied to ctx so an interrupted backfill stores what it
+	// has read.
+	wctx := context.Background()
+	st, err := openStore(wctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close(wctx)
+
+	var (
+		changes   = newChangeDetector()
+		quality   = newQualityReport()
+		batch     []model.Position
+		total     store.Result
+		snapshots int
+		problems  []string // printed at the end
+	)
+	// write stores batch. Only positions that could not be sent end the
+	// backfill; those the store refused are counted and reported.
+	write := func() error {
+		res, err := st.WritePositions(wctx, batch)
+		total.Written += res.Written
+		total.Duplicates += res.Duplicates
+		total.Dropped += res.Dropped
+		if len(res.Failed) > 0 {
+			if err == nil {
+				err = fmt.Errorf("%d positions not written", len(res.Failed))
+			}
+			return err
+		}
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		if err := st.UpsertLatest(wctx, batch); err != nil {
+			problems = append(problems, fmt.Sprintf("latest positions: %v", err))
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	queue := &snapshotQueue{files: files, read: func(f snapshotFile) []snapshot {
+		snaps, err := readSnapshots(f.path)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			problems = append(problems, err.Error())
+		}
+		fmt.Printf("%s: %d snapshots\n", f.path, len(snaps))
+		return snaps
+	}}
+	for {
+		if ctx.Err() != nil {
+			fmt.Println("Interrupted, skipping the remaining snapshots")
+			break
+		}
+		s, ok := queue.next()
+		if !ok {
+			break
+		}
+		var resp tmt.ResponseBusLocations
+		if err := json.Unmarshal(s.body, &resp); err != nil {
+			fmt.Printf("%s: snapshot of %s: %v\n", s.path, s.fetchedAt.Format(time.RFC3339), err)
+			continue
+		}
+		snapshots++
+		batch = append(batch, convertPositions(changes.changed(resp.Data), loc, quality)...)
+		if len(batch) >= backfillBatch {
+			if err := write(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := write(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println(quality.summary())
+	fmt.Printf("Backfilled %d snapshots: %d positions written, %d already stored, %d dropped\n",
+		snapshots, total.Written, total.Duplicates, total.Dropped)
+	if len(problems) > 0 {
+		fmt.Println("Problems:")
+		for _, p := range problems {
+			fmt.Println("  " + p)
+		}
+	}
+	return nil
+}
+
+// findSnapshotFiles expands directories in paths and orders the files by
+// the time of their first snapshot.
+func findSnapshotFiles(paths []string) ([]snapshotFile, error) {
+	var names []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			names = append(names, p)
+			continue
+		}
+		for _, pattern := range []string{"*.ndjson.gz", "*.ndjson", "*.json"} {
+			found, err := filepath.Glob(filepath.Join(p, pattern))
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, found...)
+		}
+	}
+
+	files := make([]snapshotFile, 0, len(names))
+	for _, name := range names {
+		if first, ok := firstSnapshotTime(name); ok {
+			files = append(files, snapshotFile{path: name, first: first})
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].first.Before(files[j].first) })
+	return files, nil
+}
+
+// archiveHour is the start of the names of archive files, which are dated
+// by the hour their snapshots were fetched in.
+const archiveHour = "tracking-20060102-15"
+
+// firstSnapshotTime returns when the first snapshot of name was fetched.
+// Archive files are dated by their name. ok is false for files without
+// tracking data, e.g. recordings of other endpoints. Files that cannot be
+// read are left to backfill to report.
+func firstSnapshotTime(name string) (first time.Time, ok bool) {
+	base := filepath.Base(name)
+	if len(base) >= len(archiveHour) {
+		if t, err := time.Parse(archiveHour, base[:len(archiveHour)]); err == nil {
+			return t, true
+		}
+	}
+	snaps, err := readSnapshots(name)
+	if len(snaps) == 0 {
+		return time.Time{}, err != nil
+	}
+	return snaps[0].fetchedAt, true
+}
+
+// readSnapshots reads the snapshots of a file, oldest first. A file is
+// either an archive file, a -record interaction or a saved response body,
+// which is dated by its modification time. The snapshots of a damaged
+// archive file are returned with the error.
+func readSnapshots(name string) ([]snapshot, error) {
+	if strings.HasSuffix(name, ".ndjson") || strings.HasSuffix(name, ".ndjson.gz") {
+		recs, err := archive.ReadFile(name)
+		snaps := make([]snapshot, len(recs))
+		for i, rec := range recs {
+			snaps[i] = snapshot{fetchedAt: rec.FetchedAt, body: rec.Body, path: name}
+		}
+		sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].fetchedAt.Before(snaps[j].fetchedAt) })
+		return snaps, err
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var in cassette.Interaction
+	if err := json.Unmarshal(b, &in); err == nil && in.Method != "" {
+		if !strings.HasSuffix(in.URL, "/getLastTrackingData") || in.Status != 200 {
+			return nil, nil
+		}
+		return []snapshot{{fetchedAt: in.Time, body: []byte(in.ResponseBody), path: name}}, nil
+	}
+	var probe struct {
+		Data []tmt.BusLocation `json:"data"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil || len(probe.Data) == 0 || probe.Data[0].LastTrackdt == "" {
+		return nil, nil // some other JSON file
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return []snapshot{{fetchedAt: fi.ModTime(), body: b, path: name}}, nil
+}
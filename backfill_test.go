@@ -0,0 +1,119 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"TMTU/archive"
+	"TMTU/store"
+)
+
+// trackingBody returns a getLastTrackingData response with one bus.
+func trackingBody(vehID int, lastTrackdt string) json.RawMessage {
+	return json.RawMessage(fmt.Sprintf(`{"status":"success","messages":"","data":[{"VehId":"%d","LastTrackdt":%q,"Latitude":"19.18","Longitude":"72.97"}]}`,
+		vehID, lastTrackdt))
+}
+
+// writeArchiveFile writes an archive file with a gzip member per entry of
+// members. All but the last member are left unfinished, like those of a
+// process that was killed before an older version appended to its file.
+func writeArchiveFile(t *testing.T, name string, members ...[]archive.Record) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i, recs := range members {
+		gz := gzip.NewWriter(f)
+		for _, rec := range recs {
+			line, _ := json.Marshal(rec)
+			gz.Write(append(line, '\n'))
+		}
+		if i < len(members)-1 {
+			gz.Flush()
+		} else if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func storedPositions(t *testing.T, cfg *Config) int {
+	t.Helper()
+	st, err := store.OpenJSONL(cfg.Store.JSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close(context.Background())
+	ps, err := st.Positions(context.Background(), 0, time.Time{}, time.Now().AddDate(10, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(ps)
+}
+
+func TestBackfillDamagedFile(t *testing.T) {
+	cfg, _ := newTestAPI(t)
+	dir := t.TempDir()
+	at := time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+	writeArchiveFile(t, filepath.Join(dir, archive.FileName(at)),
+		[]archive.Record{{FetchedAt: at, Body: trackingBody(1, "2023-11-20 15:30:00")}},
+		[]archive.Record{{FetchedAt: at.Add(time.Minute), Body: trackingBody(1, "2023-11-20 15:31:00")}})
+	writeArchiveFile(t, filepath.Join(dir, archive.FileName(at.Add(time.Hour))),
+		[]archive.Record{{FetchedAt: at.Add(time.Hour), Body: trackingBody(2, "2023-11-20 16:30:00")}})
+
+	if err := backfill(context.Background(), cfg, []string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	if n := storedPositions(t, cfg); n != 2 {
+		t.Errorf("%d positions stored, want the one before the damage and the one of the next hour", n)
+	}
+}
+
+func TestSnapshotQueue(t *testing.T) {
+	at := func(minute int) time.Time { return time.Date(2023, 11, 20, 10, minute, 0, 0, time.UTC) }
+	snaps := map[string][]snapshot{
+		// an archive hour, dated by its name
+		"tracking-20231120-10.ndjson.gz": {{fetchedAt: at(0)}, {fetchedAt: at(2)}, {fetchedAt: at(4)}},
+		// recordings of the same time
+		"a.json":                         {{fetchedAt: at(1)}},
+		"b.json":                         {{fetchedAt: at(3)}},
+		"tracking-20231120-11.ndjson.gz": {{fetchedAt: at(60)}},
+	}
+	var events []string
+	q := &snapshotQueue{
+		files: []snapshotFile{
+			{"tracking-20231120-10.ndjson.gz", at(0)},
+			{"a.json", at(1)},
+			{"b.json", at(3)},
+			{"tracking-20231120-11.ndjson.gz", at(60)},
+		},
+		read: func(f snapshotFile) []snapshot {
+			events = append(events, "read "+f.path)
+			return snaps[f.path]
+		},
+	}
+	for {
+		s, ok := q.next()
+		if !ok {
+			break
+		}
+		events = append(events, s.fetchedAt.Format("15:04"))
+	}
+	want := []string{
+		"read tracking-20231120-10.ndjson.gz", "10:00",
+		"read a.json", "10:01", "10:02",
+		"read b.json", "10:03", "10:04",
+		"read tracking-20231120-11.ndjson.gz", "11:00",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %v, want %v", events, want)
+	}
+}
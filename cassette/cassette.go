@@ -0,0 +1,204 @@
+// Package cassette records HTTP exchanges to a directory and replays them,
+// so that a crawl or a tracking session can be reproduced exactly.
+//
+// Every exchange is stored as one JSON file named after its sequence number,
+// e.g. 000042.json.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interaction is one recorded request/response pair.
+type Interaction struct {
+	Time time.Time `json:"time"`
+
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	RequestHeader http.Header `json:"request_header,omitempty"`
+	RequestBody   string      `json:"request_body,omitempty"` // e.g. the RouteNo form
+
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   string      `json:"response_body"`
+}
+
+// key identifies the request of in. The host is left out so that a
+// recording can be replayed against any base URL.
+func (in *Interaction) key() string {
+	uri := in.URL
+	if u, err := url.Parse(in.URL); err == nil {
+		uri = u.RequestURI()
+	}
+	return in.Method + " " + uri + " " + in.RequestBody
+}
+
+// Recorder is an http.RoundTripper that passes requests to Next and saves
+// every exchange to Dir.
+type Recorder struct {
+	Dir  string
+	Next http.RoundTripper // http.DefaultTransport if nil
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewRecorder creates dir if needed and returns a Recorder writing to it.
+// Numbering continues after any interactions already in dir.
+func NewRecorder(dir string, next http.RoundTripper) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{Dir: dir, Next: next, seq: len(names)}, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	next := r.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &Interaction{
+		Time:           time.Now(),
+		Method:         req.Method,
+		URL:            req.URL.String(),
+		RequestHeader:  req.Header,
+		RequestBody:    string(reqBody),
+		Status:         resp.StatusCode,
+		ResponseHeader: resp.Header,
+		ResponseBody:   string(respBody),
+	}
+	if err := r.save(in); err != nil {
+		return nil, fmt.Errorf("cassette: %w", err)
+	}
+	return resp, nil
+}
+
+func (r *Recorder) save(in *Interaction) error {
+	b, err := json.MarshalIndent(in, "", "  ")
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	return os.WriteFile(filepath.Join(r.Dir, fmt.Sprintf("%06d.json", r.seq)), b, 0644)
+}
+
+// Replayer is an http.RoundTripper answering from a recorded directory.
+// Requests are matched on method, path, query and body; repeated requests get the
+// recorded responses in their original order.
+type Replayer struct {
+	mu     sync.Mutex
+	queues map[string][]*Interaction
+}
+
+// NewReplayer loads every interaction in dir.
+func NewReplayer(dir string) (*Replayer, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("cassette: no recordings in %s", dir)
+	}
+	sort.Strings(names)
+
+	r := &Replayer{queues: make(map[string][]*Interaction)}
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		in := new(Interaction)
+		if err := json.Unmarshal(b, in); err != nil {
+			return nil, fmt.Errorf("cassette: %s: %w", name, err)
+		}
+		r.queues[in.key()] = append(r.queues[in.key()], in)
+	}
+	return r, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	key := (&Interaction{Method: req.Method, URL: req.URL.String(), RequestBody: string(reqBody)}).key()
+
+	r.mu.Lock()
+	queue := r.queues[key]
+	if len(queue) == 0 {
+		r.mu.Unlock()
+		return nil, &ExhaustedError{Request: strings.TrimSpace(key)}
+	}
+	in := queue[0]
+	r.queues[key] = queue[1:]
+	r.mu.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+		StatusCode:    in.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        in.ResponseHeader.Clone(),
+		Body:          io.NopCloser(strings.NewReader(in.ResponseBody)),
+		ContentLength: int64(len(in.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// ExhaustedError is returned by a Replayer for a request whose recorded
+// responses have all been replayed: the recorded session is over.
+type ExhaustedError struct {
+	Request string // method, path, query and body
+}
+
+func (e *ExhaustedError) Error() string {
+	return "cassette: no recorded response left for " + e.Request
+}
+
+// Permanent reports that sending the request again cannot help.
+func (e *ExhaustedError) Permanent() bool { return true }
+
+// readBody reads *body and replaces it with an equivalent unread one.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	b, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
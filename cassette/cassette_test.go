@@ -0,0 +1,88 @@
+package cassette_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"TMTU/cassette"
+	"TMTU/tmt"
+)
+
+func TestRecordReplay(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		r.ParseForm()
+		fmt.Fprintf(w, "call %d RouteNo %s", calls, r.PostFormValue("RouteNo"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	rec, err := cassette.NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(c *http.Client, base, routeNo string) (string, error) {
+		resp, err := c.PostForm(base+"/api/getRouteDetailsNew", url.Values{"RouteNo": {routeNo}})
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+	recorded := &http.Client{Transport: rec}
+	var want []string
+	for _, routeNo := range []string{"1", "2", "1"} {
+		body, err := send(recorded, ts.URL, routeNo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, body)
+	}
+
+	rep, err := cassette.NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := &http.Client{Transport: rep}
+	// Another host and the requests in another order: RouteNo 1 still gets
+	// its responses in the recorded order.
+	for _, i := range []int{1, 0, 2} {
+		routeNo := []string{"1", "2", "1"}[i]
+		body, err := send(replayed, "http://replay.invalid", routeNo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != want[i] {
+			t.Errorf("RouteNo %s: got %q, want %q", routeNo, body, want[i])
+		}
+	}
+	if calls != 3 {
+		t.Errorf("the replay reached the server, %d calls", calls)
+	}
+
+	_, err = send(replayed, "http://replay.invalid", "1")
+	var ex *cassette.ExhaustedError
+	if !errors.As(err, &ex) {
+		t.Fatalf("request after the recording: got %v, want an ExhaustedError", err)
+	}
+	if !strings.Contains(ex.Request, "getRouteDetailsNew") {
+		t.Errorf("ExhaustedError.Request = %q", ex.Request)
+	}
+	if tmt.Temporary(&tmt.TransportError{Method: "POST", Endpoint: "getRouteDetailsNew", Err: err}) {
+		t.Error("a replay that ran out is retried")
+	}
+}
+
+func TestNewReplayerEmpty(t *testing.T) {
+	if _, err := cassette.NewReplayer(t.TempDir()); err == nil {
+		t.Error("no error for a directory without recordings")
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"TMTU/cassette"
+	"TMTU/mock"
+	"TMTU/tmt"
+)
+
+// command is one mode of the program, selected by the first argument.
+type command struct {
+	name  string
+	short string // one line shown in the command list
+	long  string // shown by "<command> -help"
+	args  string // usage of the positional arguments, none are accepted if empty
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, cfg *Config) error // ctx is cancelled by SIGINT or SIGTERM
+}
+
+var (
+	commandArgs []string // positional arguments of the command
+
+	routesRaw     bool
+	routesRestart bool
+
+	diffThreshold float64
+	diffLog       string
+
+	mockListen   string
+	mockFixtures string
+	mockLimit    int
+
+	migrateDryRun bool
+)
+
+var commands = []*command{
+	{
+		name:  "stops",
+		short: "save all bus stops from getWayPoints",
+		long: `Fetches every bus stop from getWayPoints and writes them as GeoJSON
+points (tagged for JOSM) to <out>/TMTStopsDirect.json.`,
+		run: func(ctx context.Context, cfg *Config) error {
+			api, err := newAPI(cfg)
+			if err != nil {
+				return err
+			}
+			timed("Bus Stops", func() { err = waypoints(ctx, api, cfg) })
+			return err
+		},
+	},
+	{
+		name:  "routes",
+		short: "crawl all routes and the stops served by them",
+		long: `Fetches getRouteMaster and then getRouteDetailsNew for every route.
+Each route is written to <out>/TMTRoutes<RouteNo>-<RouteNum>.json and the
+stops of all routes to <out>/TMTStopsThroughRoutes.json. With -raw the
+route files contain the API response as received, which rebuild-stops can
+read back later.
+
+Progress is kept in <out>/TMTRoutesCrawl: a crawl that was interrupted or
+had routes fail resumes from there with the same route list when routes
+runs again. Failed routes are listed at the end.`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&routesRaw, "raw", false, "write route files as received from the API instead of GeoJSON")
+			fs.BoolVar(&routesRestart, "restart", false, "discard the checkpoint of an unfinished crawl and start over")
+		},
+		run: func(ctx context.Context, cfg *Config) error {
+			api, err := newAPI(cfg)
+			if err != nil {
+				return err
+			}
+			timed("Bus Routes", func() { err = routes(ctx, api, cfg, routesRaw, routesRestart) })
+			return err
+		},
+	},
+	{
+		name:  "rebuild-stops",
+		short: "rebuild TMTStopsThroughRoutes.json from a raw route crawl",
+		long: `Reads the <out>/TMTRoutes*.json files of an earlier "routes -raw" run and
+rebuilds <out>/TMTStopsThroughRoutes.json without calling the API.`,
+		run: func(ctx context.Context, cfg *Config) error {
+			return stops(cfg)
+		},
+	},
+	{
+		name:  "track",
+		short: "poll bus locations until stopped and store them",
+		long: `Polls getLastTrackingData every -interval and stores each bus whose
+position changed in the configured store (MongoDB at -mongo by default).
+Runs until interrupted: Ctrl+C or SIGTERM lets the current cycle finish,
+then the archive is flushed and the store closed. A second signal exits
+at once.`,
+		run: func(ctx context.Context, cfg *Config) error {
+			api, err := newAPI(cfg)
+			if err != nil {
+				return err
+			}
+			return buslocations(ctx, api, cfg)
+		},
+	},
+	{
+		name:  "all",
+		short: "stops, routes and then track",
+		long:  `Runs stops, routes and track one after the other.`,
+		run: func(ctx context.Context, cfg *Config) error {
+			api, err := newAPI(cfg)
+			if err != nil {
+				return err
+			}
+			timed("Bus Stops", func() { err = waypoints(ctx, api, cfg) })
+			if err != nil {
+				return err
+			}
+			timed("Bus Routes", func() { err = routes(ctx, api, cfg, false, false) })
+			if err != nil || ctx.Err() != nil {
+				return err
+			}
+			return buslocations(ctx, api, cfg)
+		},
+	},
+	{
+		name:  "route-diff",
+		short: "report stop changes between two route crawls",
+		args:  "old new",
+		long: `Compares two crawls of raw route files ("routes -raw" output directories,
+TMTRoutesCrawl checkpoints or single files) route by RouteNo and reports
+the stops added, removed, moved further than -threshold metres, renamed or
+reordered. The same comparison is appended as one JSON line to -log so the
+changes of successive crawls can be kept; -log - prints that JSON instead
+of the report.`,
+		flags: func(fs *flag.FlagSet) {
+			fs.Float64Var(&diffThreshold, "threshold", 25, "least stop move in metres that is reported")
+			fs.StringVar(&diffLog, "log", "", "JSON Lines change log to append to (default <out>/TMTRouteChanges.jsonl, - for stdout)")
+		},
+		run: func(ctx context.Context, cfg *Config) error {
+			if len(commandArgs) != 2 {
+				return errors.New("route-diff needs the old and the new crawl")
+			}
+			logPath := diffLog
+			if logPath == "" {
+				logPath = filepath.Join(cfg.OutDir, "TMTRouteChanges.jsonl")
+			}
+			return routeDiffCmd(commandArgs[0], commandArgs[1], diffThreshold, logPath)
+		},
+	},
+	{
+		name:  "backfill",
+		short: "store positions from archived tracking snapshots",
+		args:  "[file|dir ...]",
+		long: `Reads getLastTrackingData responses from archive files
+(tracking-*.ndjson.gz, default: the archive.dir directory), -record
+recordings or saved response bodies, and runs them in fetch order through
+the same change detection and conversion as track. The positions are
+written to the configured store; positions already stored are skipped, so
+backfilling the same files twice is harmless (except for a MongoDB
+time-series layout, which cannot detect duplicates).`,
+		run: func(ctx context.Context, cfg *Config) error {
+			return backfill(ctx, cfg, commandArgs)
+		},
+	},
+	{
+		name:  "migrate-timezone",
+		short: "fix timestamps of positions stored before api.timezone existed",
+		long: `Positions stored by older versions have LastTrackdt, PrevTrackDt,
+LastNCSentDate and DispatchDateTime read as UTC although the feed uses
+local time. This re-reads them in api.timezone (-timezone) for every
+document of the database that has no "tz" field, and sets "tz". Documents
+written by this version already carry "tz" and are left alone.`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&migrateDryRun, "dry-run", false, "only count the documents that would change")
+		},
+		run: func(ctx context.Context, cfg *Config) error {
+			return migrateTimezone(ctx, cfg, migrateDryRun)
+		},
+	},
+	{
+		name:  "migrate-collections",
+		short: "copy per-vehicle collections into the single positions collection",
+		long: `Copies every per-vehicle collection (named after a VehId) of the database
+into mongo.collection, creating its indexes. Positions already present
+(same VehId and LastTrackdt) are skipped, so the command can be run again
+safely. The per-vehicle collections are not removed. Set mongo.layout to
+"single" afterwards so that track writes to the new collection.`,
+		run: migrateCollections,
+	},
+	{
+		name:  "mock-server",
+		short: "serve the TMT API from fixture files for offline use",
+		long: `Serves getWayPoints, getRouteMaster, getRouteDetailsNew and
+getLastTrackingData from fixture files, including X-RateLimit-* headers.
+Each getLastTrackingData request returns the next file of
+<fixtures>/getLastTrackingData/ so buses appear to move. Point the other
+commands at it with -base-url http://localhost:8080/api.`,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&mockListen, "listen", "localhost:8080", "address to listen on")
+			fs.StringVar(&mockFixtures, "fixtures", "", "fixture directory (default: fixtures built into the binary)")
+			fs.IntVar(&mockLimit, "rate-limit", 60, "requests allowed per minute")
+		},
+		run: func(ctx context.Context, cfg *Config) error {
+			fixtures := mock.Fixtures()
+			if mockFixtures != "" {
+				fixtures = os.DirFS(mockFixtures)
+			}
+			srv, err := mock.NewServer(fixtures)
+			if err != nil {
+				return err
+			}
+			srv.Limit = mockLimit
+			hs := &http.Server{Addr: mockListen, Handler: srv}
+			go func() {
+				<-ctx.Done()
+				hs.Shutdown(context.Background())
+			}()
+			fmt.Printf("Serving mock TMT API on http://%s/api\n", mockListen)
+			if err := hs.ListenAndServe(); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+	},
+}
+
+// run parses args and executes the selected command.
+func run(args []string, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		usage(stderr)
+		return 2
+	}
+
+	// Flags are parsed against the defaults first to find -config, then
+	// replayed on top of the loaded configuration so they win over it.
+	var configPath string
+	fs := newFlagSet(cmd, defaultConfig(), stderr)
+	fs.StringVar(&configPath, "config", "", "YAML config file (default $TMTU_CONFIG or "+defaultConfigFile+")")
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 && cmd.args == "" {
+		fmt.Fprintf(stderr, "unexpected arguments: %v\n", fs.Args())
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	set := map[string]string{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = f.Value.String() })
+	overrides := newFlagSet(cmd, cfg, io.Discard)
+	overrides.String("config", "", "")
+	for name, value := range set {
+		if err := overrides.Set(name, value); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+
+	if err := os.MkdirAll(cfg.OutDir, 0750); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	commandArgs = fs.Args()
+
+	// The first SIGINT or SIGTERM asks the command to finish what it is
+	// doing; a second one kills the program.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if err := cmd.run(ctx, cfg); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// newFlagSet returns the flags of cmd bound to cfg.
+func newFlagSet(cmd *command, cfg *Config, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		synopsis := "TMTU " + cmd.name + " [flags]"
+		if cmd.args != "" {
+			synopsis += " " + cmd.args
+		}
+		fmt.Fprintf(output, "Usage: %s\n\n%s\n\nFlags:\n", synopsis, cmd.long)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.OutDir, "out", cfg.OutDir, "output directory")
+	fs.StringVar(&cfg.Mongo.URI, "mongo", cfg.Mongo.URI, "MongoDB connection string")
+	fs.DurationVar(&cfg.Track.Interval, "interval", cfg.Track.Interval, "pause between two bus location polls")
+	fs.StringVar(&cfg.API.BaseURL, "base-url", cfg.API.BaseURL, "TMT API base URL")
+	fs.StringVar(&cfg.API.Timezone, "timezone", cfg.API.Timezone, "time zone of the timestamps in the tracking feed")
+	fs.StringVar(&cfg.API.Record, "record", "", "save every API request and response to `dir`")
+	fs.StringVar(&cfg.API.Replay, "replay", "", "answer API requests from the recordings in `dir` instead of the network")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	return fs
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: TMTU <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(w, "\nRun \"TMTU <command> -help\" for the flags of a command.\n")
+}
+
+// newAPI returns the API client for cfg, recording or replaying the
+// exchanges if asked to.
+func newAPI(cfg *Config) (*tmt.Client, error) {
+	var transport http.RoundTripper
+	switch {
+	case cfg.API.Record != "" && cfg.API.Replay != "":
+		return nil, errors.New("-record and -replay cannot be used together")
+	case cfg.API.Record != "":
+		rec, err := cassette.NewRecorder(cfg.API.Record, nil)
+		if err != nil {
+			return nil, err
+		}
+		transport = rec
+	case cfg.API.Replay != "":
+		rep, err := cassette.NewReplayer(cfg.API.Replay)
+		if err != nil {
+			return nil, err
+		}
+		transport = rep
+	default:
+		transport = http.DefaultTransport
+	}
+	// The timeout turns a stalled connection into a TransportError, which
+	// the client retries.
+	api := tmt.NewClient(cfg.API.BaseURL, &http.Client{Transport: transport, Timeout: cfg.API.Timeout})
+	api.Logf = func(format string, args ...interface{}) {
+		fmt.Printf("\n"+format+"\n", args...)
+	}
+	if cfg.API.Replay == "" { // a replay has no limit to respect
+		api.Limiter = tmt.NewRateLimiter(cfg.API.CrawlDelay)
+		api.Limiter.Logf = api.Logf
+	}
+	return api, nil
+}
+
+// replayEnded reports whether err means that -replay has no recorded
+// response left for a request.
+func replayEnded(err error) bool {
+	var ex *cassette.ExhaustedError
+	return errors.As(err, &ex)
+}
+
+// timed prints how long f took, the way the old menu did.
+func timed(what string, f func()) {
+	start := time.Now()
+	fmt.Printf("Adding %s\n", what)
+	f()
+	fmt.Printf("\n%s Added in: %s\n", what, time.Since(start))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"TMTU/store"
+	"TMTU/tmt"
+)
+
+// defaultConfigFile is read when neither -config nor TMTU_CONFIG is given.
+// It is optional.
+const defaultConfigFile = "TMTU.yaml"
+
+// Config holds the runtime settings shared by all commands. Values come from
+// the defaults below, then the YAML config file, then TMTU_* environment
+// variables and finally command-line flags, each overriding the previous.
+type Config struct {
+	OutDir string `yaml:"out_dir"` // where GeoJSON and route files are written
+
+	API struct {
+		BaseURL      string        `yaml:"base_url"`      // TMT API endpoint
+		CrawlDelay   time.Duration `yaml:"crawl_delay"`   // least time between the start of two API requests
+		CrawlWorkers int           `yaml:"crawl_workers"` // getRouteDetailsNew requests in flight at once
+		Timeout      time.Duration `yaml:"timeout"`       // limit of one request including the body, 0 for none
+		Timezone     string        `yaml:"timezone"`      // zone of the timestamps in the tracking feed
+
+		Record string `yaml:"-"` // directory to save every API exchange to
+		Replay string `yaml:"-"` // directory of saved exchanges to answer from
+	} `yaml:"api"`
+
+	Store struct {
+		Backend string `yaml:"backend"` // "mongo", "sqlite" or "jsonl"
+		SQLite  string `yaml:"sqlite"`  // database file of the sqlite backend
+		JSONL   string `yaml:"jsonl"`   // directory of the jsonl backend
+	} `yaml:"store"`
+
+	Mongo struct {
+		URI        string `yaml:"uri"`
+		Database   string `yaml:"database"`
+		Layout     string `yaml:"layout"`     // store.LayoutPerVehicle, LayoutSingle or LayoutTimeseries
+		Collection string `yaml:"collection"` // used by the single and timeseries layouts
+		Latest     string `yaml:"latest"`     // collection with the latest position of each vehicle
+
+		// Settings of the time-series collection, applied when it is created.
+		Granularity string        `yaml:"granularity"`  // "seconds", "minutes" or "hours"
+		ExpireAfter time.Duration `yaml:"expire_after"` // 0 keeps positions forever
+	} `yaml:"mongo"`
+
+	Archive struct {
+		Dir string `yaml:"dir"` // raw getLastTrackingData responses go here, "" disables the archive
+	} `yaml:"archive"`
+
+	Track struct {
+		Interval       time.Duration `yaml:"interval"`        // pause between two getLastTrackingData polls
+		ReportInterval time.Duration `yaml:"report_interval"` // how often the data quality summary is printed
+		Spool          string        `yaml:"spool"`           // queue of positions the store has not taken yet, empty keeps it in memory
+	} `yaml:"track"`
+}
+
+// location returns the time zone of the API timestamps.
+func (cfg *Config) location() (*time.Location, error) {
+	loc, err := time.LoadLocation(cfg.API.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("api.timezone: %w", err)
+	}
+	return loc, nil
+}
+
+func defaultConfig() *Config {
+	cfg := &Config{OutDir: "output"}
+	cfg.API.BaseURL = tmt.DefaultBaseURL
+	// The X-RateLimit headers pace the requests; a long least interval
+	// would leave the crawl workers waiting on each other.
+	cfg.API.CrawlDelay = 250 * time.Millisecond
+	cfg.API.CrawlWorkers = 4
+	cfg.API.Timeout = 30 * time.Second
+	cfg.API.Timezone = "Asia/Kolkata"
+	cfg.Mongo.URI = "mongodb://localhost:27017"
+	cfg.Mongo.Database = "TMTU"
+	cfg.Store.Backend = "mongo"
+	cfg.Store.SQLite = "TMTU.sqlite"
+	cfg.Store.JSONL = "positions"
+	cfg.Mongo.Layout = store.LayoutPerVehicle
+	cfg.Mongo.Collection = "positions"
+	cfg.Mongo.Latest = "latest"
+	cfg.Mongo.Granularity = "seconds"
+	cfg.Archive.Dir = "archive"
+	cfg.Track.Interval = 7 * time.Second
+	cfg.Track.ReportInterval = 10 * time.Minute
+	cfg.Track.Spool = "spool"
+	return cfg
+}
+
+// loadConfig returns the defaults overridden by the config file at path and
+// by the environment. An empty path falls back to TMTU_CONFIG and then to
+// defaultConfigFile, which may be missing.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	optional := false
+	if path == "" {
+		path = os.Getenv("TMTU_CONFIG")
+	}
+	if path == "" {
+		path, optional = defaultConfigFile, true
+	}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(b, cfg); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	case optional && errors.Is(err, fs.ErrNotExist):
+	default:
+		return nil, err
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides cfg with the TMTU_* variables found by lookup.
+func (cfg *Config) applyEnv(lookup func(string) (string, bool)) error {
+	strs := map[string]*string{
+		"TMTU_OUT_DIR":           &cfg.OutDir,
+		"TMTU_API_BASE_URL":      &cfg.API.BaseURL,
+		"TMTU_API_TIMEZONE":      &cfg.API.Timezone,
+		"TMTU_MONGO_URI":         &cfg.Mongo.URI,
+		"TMTU_MONGO_DATABASE":    &cfg.Mongo.Database,
+		"TMTU_MONGO_LAYOUT":      &cfg.Mongo.Layout,
+		"TMTU_MONGO_COLLECTION":  &cfg.Mongo.Collection,
+		"TMTU_MONGO_LATEST":      &cfg.Mongo.Latest,
+		"TMTU_STORE_BACKEND":     &cfg.Store.Backend,
+		"TMTU_STORE_SQLITE":      &cfg.Store.SQLite,
+		"TMTU_STORE_JSONL":       &cfg.Store.JSONL,
+		"TMTU_ARCHIVE_DIR":       &cfg.Archive.Dir,
+		"TMTU_TRACK_SPOOL":       &cfg.Track.Spool,
+		"TMTU_MONGO_GRANULARITY": &cfg.Mongo.Granularity,
+	}
+	for name, p := range strs {
+		if v, ok := lookup(name); ok {
+			*p = v
+		}
+	}
+
+	ints := map[string]*int{
+		"TMTU_API_CRAWL_WORKERS": &cfg.API.CrawlWorkers,
+	}
+	for name, p := range ints {
+		if v, ok := lookup(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*p = n
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"TMTU_API_CRAWL_DELAY":       &cfg.API.CrawlDelay,
+		"TMTU_API_TIMEOUT":           &cfg.API.Timeout,
+		"TMTU_TRACK_INTERVAL":        &cfg.Track.Interval,
+		"TMTU_TRACK_REPORT_INTERVAL": &cfg.Track.ReportInterval,
+		"TMTU_MONGO_EXPIRE_AFTER":    &cfg.Mongo.ExpireAfter,
+	}
+	for name, p := range durations {
+		if v, ok := lookup(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*p = d
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) validate() error {
+	switch cfg.Store.Backend {
+	case "mongo", "sqlite", "jsonl":
+	default:
+		return fmt.Errorf("store.backend: unknown backend %q", cfg.Store.Backend)
+	}
+	switch cfg.Mongo.Layout {
+	case store.LayoutPerVehicle, store.LayoutSingle, store.LayoutTimeseries:
+	default:
+		return fmt.Errorf("mongo.layout: unknown layout %q", cfg.Mongo.Layout)
+	}
+	switch cfg.Mongo.Granularity {
+	case "seconds", "minutes", "hours":
+	default:
+		return fmt.Errorf("mongo.granularity: must be seconds, minutes or hours, not %q", cfg.Mongo.Granularity)
+	}
+	if cfg.API.CrawlWorkers < 1 {
+		return fmt.Errorf("api.crawl_workers: must be at least 1")
+	}
+	if cfg.API.Timeout < 0 {
+		return fmt.Errorf("api.timeout: must not be negative")
+	}
+	if cfg.Mongo.ExpireAfter < 0 {
+		return fmt.Errorf("mongo.expire_after: must not be negative")
+	}
+	return nil
+}
+
+// openStore opens the position store selected by cfg.Store.Backend.
+func openStore(ctx context.Context, cfg *Config) (store.Store, error) {
+	switch cfg.Store.Backend {
+	case "sqlite":
+		return store.OpenSQLite(ctx, cfg.Store.SQLite)
+	case "jsonl":
+		return store.OpenJSONL(cfg.Store.JSONL)
+	}
+	return store.OpenMongo(ctx, store.MongoOptions{
+		URI:              cfg.Mongo.URI,
+		Database:         cfg.Mongo.Database,
+		Layout:           cfg.Mongo.Layout,
+		Collection:       cfg.Mongo.Collection,
+		LatestCollection: cfg.Mongo.Latest,
+		Granularity:      cfg.Mongo.Granularity,
+		ExpireAfter:      cfg.Mongo.ExpireAfter,
+		Logf: func(format string, args ...interface{}) {
+			fmt.Printf("\n"+format+"\n", args...)
+		},
+	})
+}
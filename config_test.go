@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// TestApplyEnvDocumented checks that every variable documented in
+// TMTU.example.yaml is read.
+func TestApplyEnvDocumented(t *testing.T) {
+	b, err := os.ReadFile("TMTU.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := regexp.MustCompile(`\[(TMTU_[A-Z_]+)\]`).FindAllStringSubmatch(string(b), -1)
+	if len(names) == 0 {
+		t.Fatal("no variables found in TMTU.example.yaml")
+	}
+	values := map[string]string{}
+	for _, m := range names {
+		values[m[1]] = "1s" // parses as a string and a duration
+	}
+	values["TMTU_API_CRAWL_WORKERS"] = "1"
+
+	looked := map[string]bool{}
+	cfg := defaultConfig()
+	err = cfg.applyEnv(func(name string) (string, bool) {
+		looked[name] = true
+		v, ok := values[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range values {
+		if !looked[name] {
+			t.Errorf("%s is documented but not read", name)
+		}
+	}
+	if cfg.Mongo.ExpireAfter != time.Second {
+		t.Errorf("TMTU_MONGO_EXPIRE_AFTER: mongo.expire_after = %s", cfg.Mongo.ExpireAfter)
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	for _, name := range []string{"TMTU_API_CRAWL_WORKERS", "TMTU_TRACK_INTERVAL", "TMTU_MONGO_EXPIRE_AFTER"} {
+		cfg := defaultConfig()
+		err := cfg.applyEnv(func(n string) (string, bool) { return "soon", n == name })
+		if err == nil {
+			t.Errorf("%s=soon: no error", name)
+		}
+	}
+}
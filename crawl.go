@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"TMTU/tmt"
+)
+
+// crawlCheckpoint keeps the progress of a routes crawl in a directory so an
+// interrupted or partly failed crawl can continue where it stopped. It
+// holds the getRouteMaster response the crawl works from and the
+// getRouteDetailsNew response of every route done so far, one file each.
+type crawlCheckpoint struct {
+	dir string
+}
+
+const routeMasterFile = "getRouteMaster.json"
+
+// routeMaster returns the route list of the crawl in progress and when it
+// was fetched. ok is false if there is none.
+func (c crawlCheckpoint) routeMaster() (master *tmt.ResponseRouteMaster, fetched time.Time, ok bool, err error) {
+	name := filepath.Join(c.dir, routeMasterFile)
+	b, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, false, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	master = new(tmt.ResponseRouteMaster)
+	if err := json.Unmarshal(b, master); err != nil {
+		return nil, time.Time{}, false, err
+	}
+	master.Raw = b
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return master, fi.ModTime(), true, nil
+}
+
+// start begins a new crawl of master.
+func (c crawlCheckpoint) start(master *tmt.ResponseRouteMaster) error {
+	if err := c.remove(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0750); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.dir, routeMasterFile), master.Raw)
+}
+
+// route returns the details of routeNo if the crawl has them already.
+func (c crawlCheckpoint) route(routeNo string) (*tmt.ResponseRouteNo, bool, error) {
+	b, err := os.ReadFile(filepath.Join(c.dir, routeNo+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var result tmt.ResponseRouteNo
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, false, err
+	}
+	if len(result.Data) == 0 {
+		return nil, false, nil // fetch it again
+	}
+	result.Raw = b
+	return &result, true, nil
+}
+
+// done records the details of routeNo.
+func (c crawlCheckpoint) done(routeNo string, details *tmt.ResponseRouteNo) error {
+	return writeFileAtomic(filepath.Join(c.dir, routeNo+".json"), details.Raw)
+}
+
+// remove forgets the crawl.
+func (c crawlCheckpoint) remove() error {
+	return os.RemoveAll(c.dir)
+}
+
+// writeFileAtomic replaces name with b so that name is either the old or
+// the new content, also after a crash.
+func writeFileAtomic(name string, b []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"TMTU/tmt"
+)
+
+const routeManifestFile = "TMTCrawlManifest.json"
+
+// routeManifest records what the last complete routes crawl wrote, so the
+// next crawl only rewrites the route files whose routes changed.
+type routeManifest struct {
+	Updated time.Time                `json:"updated"`
+	Raw     bool                     `json:"raw"`    // route files hold the responses as received
+	Routes  map[string]manifestEntry `json:"routes"` // by RouteNo
+}
+
+type manifestEntry struct {
+	RouteNum string `json:"route_num"`
+	File     string `json:"file"`
+	Hash     string `json:"sha256"` // of the route, see routeHash
+}
+
+// routeChange is how a route compares to the manifest of the last crawl.
+type routeChange int
+
+const (
+	routeUnchanged routeChange = iota
+	routeAdded
+	routeModified
+)
+
+// loadRouteManifest reads the manifest at path. A missing manifest is
+// empty, as before the first crawl.
+func loadRouteManifest(path string) (*routeManifest, error) {
+	m := &routeManifest{Routes: make(map[string]manifestEntry)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if m.Routes == nil {
+		m.Routes = make(map[string]manifestEntry)
+	}
+	return m, nil
+}
+
+func (m *routeManifest) save(path string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// routeHash identifies the static content of a getRouteDetailsNew response:
+// its data without the vehicles currently on the route, which change on
+// every request.
+func routeHash(details *tmt.ResponseRouteNo) (string, error) {
+	data := make([]tmt.Route, len(details.Data))
+	for i, r := range details.Data {
+		r.RouteDetails = append([]tmt.RouteDetail(nil), r.RouteDetails...)
+		for j := range r.RouteDetails {
+			r.RouteDetails[j].Waypoints.Allvehicle = nil
+		}
+		data[i] = r
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// routeFileName is the name of the TMTRoutes file of route.
+func routeFileName(route tmt.RouteMaster) string {
+	return fmt.Sprintf("TMTRoutes%s-%s.json", route.RouteNo, route.RouteNum)
+}
+
+// saveRoute writes the route file of route unless the last crawl, as told
+// by old, already wrote the same route in the same format.
+func saveRoute(cfg *Config, route tmt.RouteMaster, details *tmt.ResponseRouteNo, raw bool, old *routeManifest) (manifestEntry, routeChange, error) {
+	hash, err := routeHash(details)
+	if err != nil {
+		return manifestEntry{}, 0, err
+	}
+	entry := manifestEntry{RouteNum: route.RouteNum, File: routeFileName(route), Hash: hash}
+
+	prev, known := old.Routes[route.RouteNo]
+	change := routeUnchanged
+	switch {
+	case !known:
+		change = routeAdded
+	case prev.Hash != hash:
+		change = routeModified
+	}
+	_, statErr := os.Stat(filepath.Join(cfg.OutDir, entry.File))
+	if change == routeUnchanged && old.Raw == raw && prev.File == entry.File && statErr == nil {
+		return entry, change, nil
+	}
+	return entry, change, writeRouteFile(cfg, route, details, raw)
+}
+
+// removeStaleRouteFiles deletes the route files of old that are not in m,
+// those of removed routes or of routes whose RouteNum changed, and returns
+// the removed RouteNo values.
+func removeStaleRouteFiles(cfg *Config, old, m *routeManifest) ([]string, error) {
+	current := make(map[string]bool, len(m.Routes))
+	for _, e := range m.Routes {
+		current[e.File] = true
+	}
+	var removed []string
+	for routeNo, e := range old.Routes {
+		if _, ok := m.Routes[routeNo]; !ok {
+			removed = append(removed, routeNo)
+		}
+		if current[e.File] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cfg.OutDir, e.File)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	sortRouteNos(removed)
+	return removed, nil
+}
+
+// writeIfChanged writes b to name unless name already holds b.
+func writeIfChanged(name string, b []byte) error {
+	if old, err := os.ReadFile(name); err == nil && bytes.Equal(old, b) {
+		return nil
+	}
+	return os.WriteFile(name, b, 0644)
+}
+
+// sortRouteNos sorts RouteNo values numerically where they are numbers.
+func sortRouteNos(nos []string) {
+	sort.Slice(nos, func(i, j int) bool {
+		a, errA := strconv.Atoi(nos[i])
+		b, errB := strconv.Atoi(nos[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return nos[i] < nos[j]
+	})
+}
+
+// routeChangeSummary describes the route changes found by a crawl.
+func routeChangeSummary(added, removed, modified []string, unchanged int) string {
+	list := func(nos []string) string {
+		if len(nos) == 0 {
+			return ""
+		}
+		sortRouteNos(nos)
+		return " (" + strings.Join(nos, ", ") + ")"
+	}
+	return fmt.Sprintf("Routes since the last crawl: %d added%s, %d removed%s, %d modified%s, %d unchanged",
+		len(added), list(added), len(removed), list(removed), len(modified), list(modified), unchanged)
+}
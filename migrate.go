@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"TMTU/store"
+)
+
+// timestampFields are the position fields taken from API timestamps.
+var timestampFields = []string{"LastTrackdt", "PrevTrackDt", "LastNCSentDate", "DispatchDateTime"}
+
+// migrateBatch is the number of updates sent in one BulkWrite.
+const migrateBatch = 500
+
+// migrateTimezone corrects positions stored before timestamps were read in
+// cfg.API.Timezone: their wall-clock time was taken as UTC. Such documents
+// have no "tz" field; each of their timestamps is re-read in the configured
+// zone and "tz" is set, so running the migration twice is harmless. A
+// document whose corrected position the new version has already stored,
+// typically the last positions seen before an upgrade, is deleted.
+func migrateTimezone(ctx context.Context, cfg *Config, dryRun bool) error {
+	loc, err := cfg.location()
+	if err != nil {
+		return err
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(cfg.Mongo.Database)
+	names, err := db.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+
+	total, totalRemoved := 0, 0
+	for _, name := range names {
+		if strings.HasPrefix(name, "system.") {
+			continue
+		}
+		n, removed, err := migrateCollectionTimezone(ctx, db.Collection(name), loc, dryRun)
+		if err != nil {
+			return fmt.Errorf("collection %s: %w", name, err)
+		}
+		if removed > 0 {
+			fmt.Printf("%s: %d documents, %d of them already stored by the new version and deleted\n", name, n, removed)
+		} else if n > 0 {
+			fmt.Printf("%s: %d documents\n", name, n)
+		}
+		total += n - removed
+		totalRemoved += removed
+	}
+	verb := "Corrected"
+	if dryRun {
+		verb = "Would correct"
+	}
+	fmt.Printf("%s %d documents to %s\n", verb, total, loc)
+	if totalRemoved > 0 {
+		fmt.Printf("Deleted %d documents already stored by the new version\n", totalRemoved)
+	}
+	return nil
+}
+
+// migrateCollectionTimezone corrects the documents of coll without "tz".
+// It returns how many it found and how many of those it deleted because
+// the unique VehId/LastTrackdt index already held their corrected position.
+func migrateCollectionTimezone(ctx context.Context, coll *mongo.Collection, loc *time.Location, dryRun bool) (n, removed int, err error) {
+	filter := bson.D{{Key: "tz", Value: bson.D{{Key: "$exists", Value: false}}}}
+	projection := bson.D{}
+	for _, field := range timestampFields {
+		projection = append(projection, bson.E{Key: field, Value: 1})
+	}
+	cur, err := coll.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cur.Close(ctx)
+
+	var (
+		models []mongo.WriteModel
+		ids    []interface{} // of the documents updated by models
+	)
+	flush := func() error {
+		if len(models) == 0 || dryRun {
+			models, ids = models[:0], ids[:0]
+			return nil
+		}
+		_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		dups, err := alreadyStored(err)
+		if len(dups) > 0 {
+			stale := make([]interface{}, len(dups))
+			for i, d := range dups {
+				stale[i] = ids[d]
+			}
+			res, derr := coll.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: stale}}}, filter[0]})
+			if derr != nil {
+				return derr
+			}
+			removed += int(res.DeletedCount)
+		}
+		models, ids = models[:0], ids[:0]
+		return err
+	}
+
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return n, removed, err
+		}
+		set := bson.D{{Key: "tz", Value: loc.String()}}
+		for _, field := range timestampFields {
+			dt, ok := doc[field].(primitive.DateTime)
+			if !ok {
+				continue
+			}
+			t := dt.Time().UTC()
+			if t.Year() <= 1 {
+				continue // unparsed timestamp stored as the zero time
+			}
+			fixed := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+			set = append(set, bson.E{Key: field, Value: primitive.NewDateTimeFromTime(fixed)})
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: doc["_id"]}, filter[0]}).
+			SetUpdate(bson.D{{Key: "$set", Value: set}}))
+		ids = append(ids, doc["_id"])
+		n++
+		if len(models) == migrateBatch {
+			if err := flush(); err != nil {
+				return n, removed, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return n, removed, err
+	}
+	return n, removed, flush()
+}
+
+// alreadyStored returns the indexes of the updates of a BulkWrite that
+// failed with err because the unique VehId/LastTrackdt index already holds
+// a document for the corrected timestamp, and an error for everything else.
+func alreadyStored(err error) ([]int, error) {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil {
+		return nil, err
+	}
+	var (
+		dups []int
+		msgs []string
+	)
+	for _, we := range bwe.WriteErrors {
+		if mongo.IsDuplicateKeyError(we) {
+			dups = append(dups, we.Index)
+		} else {
+			msgs = append(msgs, we.Message)
+		}
+	}
+	if len(msgs) > 0 {
+		return dups, fmt.Errorf("%d documents not corrected: %s", len(msgs), strings.Join(msgs, "; "))
+	}
+	return dups, nil
+}
+
+// migrateCollections copies the per-vehicle collections (named after their
+// VehId) into cfg.Mongo.Collection. Documents are matched on VehId and
+// LastTrackdt and only inserted when missing, so the copy can be repeated or
+// resumed. The source collections are left in place.
+func migrateCollections(ctx context.Context, cfg *Config) error {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(cfg.Mongo.Database)
+	target := db.Collection(cfg.Mongo.Collection)
+	if err := store.EnsureSingleIndexes(ctx, target); err != nil {
+		return err
+	}
+	names, err := db.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+
+	var copied, existing int64
+	for _, name := range names {
+		if !store.IsVehicleCollection(name) {
+			continue
+		}
+		c, e, err := copyPositions(ctx, db.Collection(name), target)
+		if err != nil {
+			return fmt.Errorf("collection %s: %w", name, err)
+		}
+		fmt.Printf("%s: %d copied, %d already present\n", name, c, e)
+		copied += c
+		existing += e
+	}
+	fmt.Printf("Copied %d positions into %s (%d already present)\n", copied, cfg.Mongo.Collection, existing)
+	return nil
+}
+
+func copyPositions(ctx context.Context, src, dst *mongo.Collection) (copied, existing int64, err error) {
+	cur, err := src.Find(ctx, bson.D{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cur.Close(ctx)
+
+	var models []mongo.WriteModel
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		res, err := dst.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if res != nil {
+			copied += res.UpsertedCount
+			existing += res.MatchedCount
+		}
+		models = models[:0]
+		return err
+	}
+
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return copied, existing, err
+		}
+		if doc["VehId"] == nil || doc["LastTrackdt"] == nil {
+			continue
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "VehId", Value: doc["VehId"]}, {Key: "LastTrackdt", Value: doc["LastTrackdt"]}}).
+			SetUpdate(bson.D{{Key: "$setOnInsert", Value: doc}}).
+			SetUpsert(true))
+		if len(models) == migrateBatch {
+			if err := flush(); err != nil {
+				return copied, existing, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return copied, existing, err
+	}
+	return copied, existing, flush()
+}
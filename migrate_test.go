@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestAlreadyStored(t *testing.T) {
+	// Updates 1 and 3 would move old documents onto positions track stored
+	// after the upgrade; update 2 failed for another reason.
+	dup := func(i int) mongo.BulkWriteError {
+		return mongo.BulkWriteError{WriteError: mongo.WriteError{Index: i, Code: 11000,
+			Message: "E11000 duplicate key error collection: TMTU.211 index: VehId_1_LastTrackdt_1"}}
+	}
+	err := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{dup(1), dup(3)}}
+	if dups, err := alreadyStored(err); err != nil || !reflect.DeepEqual(dups, []int{1, 3}) {
+		t.Errorf("duplicates only: %v, %v", dups, err)
+	}
+
+	other := mongo.BulkWriteError{WriteError: mongo.WriteError{Index: 2, Code: 2, Message: "bad value"}}
+	err = mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{dup(1), other}}
+	if dups, err := alreadyStored(err); err == nil || !strings.Contains(err.Error(), "bad value") || !reflect.DeepEqual(dups, []int{1}) {
+		t.Errorf("with another error: %v, %v", dups, err)
+	}
+
+	err = mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Code: 64}, WriteErrors: []mongo.BulkWriteError{dup(1)}}
+	if dups, got := alreadyStored(err); got == nil || dups != nil {
+		t.Errorf("write concern error: %v, %v", dups, got)
+	}
+
+	lost := errors.New("connection reset")
+	if dups, got := alreadyStored(lost); got != lost || dups != nil {
+		t.Errorf("other error: %v, %v", dups, got)
+	}
+	if dups, got := alreadyStored(nil); got != nil || dups != nil {
+		t.Errorf("no error: %v, %v", dups, got)
+	}
+}
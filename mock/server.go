@@ -0,0 +1,137 @@
+// Package mock serves the TMT API endpoints from fixture files so that the
+// crawler and the tracker can run without access to the real API.
+//
+// A fixture directory contains
+//
+//	getWayPoints.json
+//	getRouteMaster.json
+//	getRouteDetailsNew/<RouteNo>.json
+//	getLastTrackingData/*.json
+//
+// The getLastTrackingData files are served one per request in name order,
+// starting over after the last one, to script moving buses.
+package mock
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//go:embed fixtures
+var embedded embed.FS
+
+// Fixtures returns the fixture set compiled into the binary.
+func Fixtures() fs.FS {
+	sub, err := fs.Sub(embedded, "fixtures")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Server is an http.Handler imitating the TMT API. Requests are matched on
+// the last path element, so it can be mounted under any base URL.
+type Server struct {
+	// Limit is the number of requests allowed per Window, reported in the
+	// X-RateLimit-* headers. Requests over the limit get 429 Too Many Requests.
+	Limit  int
+	Window time.Duration
+
+	fixtures fs.FS
+	frames   []string
+
+	mu          sync.Mutex
+	next        int // index into frames
+	used        int // requests in the current window
+	windowStart time.Time
+}
+
+// NewServer returns a Server reading from fixtures.
+func NewServer(fixtures fs.FS) (*Server, error) {
+	frames, err := fs.Glob(fixtures, "getLastTrackingData/*.json")
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("mock: no getLastTrackingData/*.json fixtures")
+	}
+	sort.Strings(frames)
+	return &Server{
+		Limit:    60,
+		Window:   time.Minute,
+		fixtures: fixtures,
+		frames:   frames,
+	}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.allow(w) {
+		http.Error(w, "Too Many Attempts.", http.StatusTooManyRequests)
+		return
+	}
+
+	var name string
+	switch endpoint := path.Base(r.URL.Path); endpoint {
+	case "getWayPoints", "getRouteMaster":
+		name = endpoint + ".json"
+	case "getRouteDetailsNew":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		routeNo := r.PostFormValue("RouteNo")
+		if _, err := strconv.Atoi(routeNo); err != nil {
+			http.Error(w, "invalid RouteNo", http.StatusBadRequest)
+			return
+		}
+		name = path.Join(endpoint, routeNo+".json")
+	case "getLastTrackingData":
+		s.mu.Lock()
+		name = s.frames[s.next]
+		s.next = (s.next + 1) % len(s.frames)
+		s.mu.Unlock()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := fs.ReadFile(s.fixtures, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// allow counts the request against the rate limit and sets the
+// X-RateLimit-* headers. It reports whether the request may proceed.
+func (s *Server) allow(w http.ResponseWriter) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.windowStart) >= s.Window {
+		s.windowStart, s.used = now, 0
+	}
+	reset := s.windowStart.Add(s.Window)
+
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(s.Limit))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if s.used >= s.Limit {
+		h.Set("X-RateLimit-Remaining", "0")
+		h.Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+		return false
+	}
+	s.used++
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(s.Limit-s.used))
+	return true
+}
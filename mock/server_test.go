@@ -0,0 +1,113 @@
+package mock
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T, limit int) *httptest.Server {
+	t.Helper()
+	srv, err := NewServer(Fixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Limit = limit
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func get(t *testing.T, u string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func TestServerEndpoints(t *testing.T) {
+	ts := newTestServer(t, 100)
+
+	for _, endpoint := range []string{"getWayPoints", "getRouteMaster"} {
+		resp, body := get(t, ts.URL+"/api/"+endpoint)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, `"success"`) {
+			t.Errorf("%s: %s %q", endpoint, resp.Status, body)
+		}
+	}
+
+	resp, err := http.PostForm(ts.URL+"/api/getRouteDetailsNew", url.Values{"RouteNo": {"3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("getRouteDetailsNew RouteNo 3: %s", resp.Status)
+	}
+	for _, routeNo := range []string{"99", "x"} {
+		resp, err := http.PostForm(ts.URL+"/api/getRouteDetailsNew", url.Values{"RouteNo": {routeNo}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("getRouteDetailsNew RouteNo %s: got 200", routeNo)
+		}
+	}
+	if resp, _ := get(t, ts.URL+"/api/getRouteDetailsNew"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET getRouteDetailsNew: %s, want 405", resp.Status)
+	}
+	if resp, _ := get(t, ts.URL+"/api/nothing"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown endpoint: %s, want 404", resp.Status)
+	}
+}
+
+func TestServerTrackingFrames(t *testing.T) {
+	ts := newTestServer(t, 100)
+
+	var bodies []string
+	for i := 0; i < 4; i++ {
+		_, body := get(t, ts.URL+"/api/getLastTrackingData")
+		bodies = append(bodies, body)
+	}
+	if bodies[0] == bodies[1] || bodies[1] == bodies[2] {
+		t.Error("successive frames are the same")
+	}
+	if bodies[3] != bodies[0] {
+		t.Error("frames do not start over after the last one")
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	ts := newTestServer(t, 2)
+
+	for want := 1; want >= 0; want-- {
+		resp, _ := get(t, ts.URL+"/api/getRouteMaster")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request within the limit: %s", resp.Status)
+		}
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got != strconv.Itoa(want) {
+			t.Errorf("X-RateLimit-Remaining = %q, want %d", got, want)
+		}
+		if resp.Header.Get("X-RateLimit-Limit") != "2" || resp.Header.Get("X-RateLimit-Reset") == "" {
+			t.Errorf("headers %v", resp.Header)
+		}
+	}
+	resp, _ := get(t, ts.URL+"/api/getRouteMaster")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: %s, want 429", resp.Status)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+}
@@ -0,0 +1,307 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"TMTU/tmt"
+)
+
+// TimeLayout is the format of the timestamps in the API.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// FieldError describes an API field that could not be converted.
+type FieldError struct {
+	Field    string // API field name, e.g. "LastTrackdt"
+	Value    string
+	Err      error
+	Required bool // the record is unusable without this field
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s %q: %v", e.Field, e.Value, e.Err)
+}
+
+func (e FieldError) Unwrap() error { return e.Err }
+
+// FieldErrors is returned by the conversion functions when one or more fields
+// could not be converted.
+type FieldErrors []FieldError
+
+func (errs FieldErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Rejected reports whether a required field is among errs, meaning the
+// converted value must not be used.
+func (errs FieldErrors) Rejected() bool {
+	for _, e := range errs {
+		if e.Required {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields returns the names of the fields in errs.
+func (errs FieldErrors) Fields() []string {
+	fields := make([]string, len(errs))
+	for i, e := range errs {
+		fields[i] = e.Field
+	}
+	return fields
+}
+
+var (
+	errMissing    = errors.New("missing")
+	errOnOff      = errors.New("not ON or OFF")
+	errOutOfRange = errors.New("out of range")
+)
+
+// PositionFromAPI converts a getLastTrackingData entry, reading its
+// timestamps as wall-clock time in loc. VehId, LastTrackdt, Latitude and
+// Longitude are required; if any of them fails the returned FieldErrors is
+// Rejected. Other fields that fail are left at their zero value and listed in
+// Position.Invalid. Empty optional fields are not errors.
+func PositionFromAPI(b tmt.BusLocation, loc *time.Location) (Position, error) {
+	p := parser{loc: loc}
+	lat := p.coordinate("Latitude", b.Latitude, 90)
+	lon := p.coordinate("Longitude", b.Longitude, 180)
+	pos := Position{
+		IdxTrackidPk: b.IdxTrackidPk,
+		Vehicle: Vehicle{
+			ID: p.int("VehId", b.VehID, true),
+			No: b.GetVehicle.VehNo,
+		},
+		CmpID:            p.int("CmpId", b.CmpID, false),
+		LastTrackdt:      p.time("LastTrackdt", b.LastTrackdt, true),
+		NCSent:           zeroAsEmpty(b.NCSent),
+		CSent:            zeroAsEmpty(b.CSent),
+		PrevTrackDt:      p.time("PrevTrackDt", b.PrevTrackDt, false),
+		LastNCSentDate:   p.time("LastNCSentDate", b.LastNCSentDate, false),
+		City:             b.City,
+		Speed:            p.float("Speed", b.Speed, false),
+		ImagePath:        b.ImagePath,
+		AC:               p.onOff("AC", b.AC),
+		Ignition:         p.onOff("Ignition", b.Ignition),
+		AUX1:             p.onOff("AUX1", b.AUX1),
+		DI4:              p.onOff("DI4", b.DI4),
+		Fuel:             p.float("Fuel", b.Fuel, false),
+		Temparature:      zeroAsEmpty(b.Temparature),
+		WPointNo:         b.WPointNo,
+		Odometer:         p.float("Odometer", b.Odometer, false),
+		Distance:         p.float("Distance", b.Distance, false),
+		ETATime:          p.float("ETATime", b.ETATime, false),
+		ETARoute:         b.ETARoute,
+		ETAOldTime:       p.float("ETAOldTime", b.ETAOldTime, false),
+		Routeflag:        p.bool("routeflag", b.Routeflag),
+		ETARouteName:     b.ETARouteName,
+		DirectionFrom:    b.DirectionFrom,
+		DirectionTo:      b.DirectionTo,
+		DispatchDateTime: p.time("DispatchDateTime", b.DispatchDateTime, false),
+		ETATime1:         p.float("ETATime1", b.ETATime1, false),
+		ETAOldTime1:      p.float("ETAOldTime1", b.ETAOldTime1, false),
+		Routeflag1:       b.Routeflag1,
+		RouteNo:          p.int("RouteNo", b.RouteNo, false),
+		WaybillNo:        p.int("WaybillNo", b.WaybillNo, false),
+		Lastwaypointid:   b.Lastwaypointid,
+		Token:            p.int("token", b.Token, false),
+		Avgspeed:         p.float("avgspeed", b.Avgspeed, false),
+		Location:         NewPoint(lon, lat),
+		TZ:               loc.String(),
+	}
+	if p.errs == nil {
+		return pos, nil
+	}
+	pos.Invalid = p.errs.Fields()
+	return pos, p.errs
+}
+
+// StopFromAPI converts a getWayPoints entry. All of WPointNo, WpointName,
+// Latitude and Longitude are required.
+func StopFromAPI(w tmt.Waypoint) (Stop, error) {
+	var p parser
+	p.present("WpointName", w.WpointName, true)
+	stop := Stop{
+		No:        p.int("WPointNo", w.WPointNo, true),
+		Name:      strings.TrimSpace(w.WpointName),
+		NameEng:   w.WpointNameEng,
+		GroupType: w.GroupType,
+	}
+	lat := p.coordinate("Latitude", w.Latitude, 90)
+	lon := p.coordinate("Longitude", w.Longitude, 180)
+	stop.Location = NewPoint(lon, lat)
+	if p.errs == nil {
+		return stop, nil
+	}
+	return stop, p.errs
+}
+
+// RouteFromAPI converts a getRouteDetailsNew route. A route detail whose
+// stop or SequenceNo cannot be converted is left out of Route.Stops; the
+// returned FieldErrors lists why, with the field names prefixed by the
+// position of the detail, e.g. "route_details[3].SequenceNo".
+func RouteFromAPI(r tmt.Route) (Route, error) {
+	var p parser
+	route := Route{
+		No:        r.RouteNo,
+		Num:       r.RouteNum,
+		Name:      r.RouteName,
+		Direction: r.RouteDirection,
+		Stops:     make([]RouteStop, 0, len(r.RouteDetails)),
+	}
+	for i, d := range r.RouteDetails {
+		n := len(p.errs)
+		prefix := fmt.Sprintf("route_details[%d].", i)
+		seq := p.int(prefix+"SequenceNo", d.SequenceNo, true)
+		stop, err := StopFromAPI(tmt.Waypoint{
+			WpointName: d.Waypoints.WpointName,
+			WPointNo:   d.Waypoints.WPointNo,
+			Longitude:  d.Waypoints.Longitude,
+			Latitude:   d.Waypoints.Latitude,
+			GroupType:  d.Waypoints.GroupType,
+		})
+		var errs FieldErrors
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				e.Field = prefix + "waypoints." + e.Field
+				p.errs = append(p.errs, e)
+			}
+		}
+		if len(p.errs) == n {
+			route.Stops = append(route.Stops, RouteStop{Sequence: seq, Stop: stop})
+		}
+	}
+	if p.errs == nil {
+		return route, nil
+	}
+	return route, p.errs
+}
+
+// parser converts API strings, collecting the failures.
+type parser struct {
+	loc  *time.Location // of timestamps, UTC if nil
+	errs FieldErrors
+}
+
+func (p *parser) fail(field, value string, err error, required bool) {
+	p.errs = append(p.errs, FieldError{Field: field, Value: value, Err: err, Required: required})
+}
+
+// present reports whether value holds data. Empty values are only an error
+// for required fields.
+func (p *parser) present(field, value string, required bool) bool {
+	if strings.TrimSpace(value) != "" {
+		return true
+	}
+	if required {
+		p.fail(field, value, errMissing, true)
+	}
+	return false
+}
+
+func (p *parser) int(field, value string, required bool) int {
+	if !p.present(field, value, required) {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		p.fail(field, value, unwrapNumError(err), required)
+	}
+	return n
+}
+
+func (p *parser) float(field, value string, required bool) float64 {
+	if !p.present(field, value, required) {
+		return 0
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		p.fail(field, value, unwrapNumError(err), required)
+	}
+	return f
+}
+
+// coordinate parses a required latitude or longitude, which MongoDB's
+// 2dsphere index only accepts within ±limit degrees. The API sends 0 for
+// coordinates it does not have; none of its stops or buses is near 0,0.
+func (p *parser) coordinate(field, value string, limit float64) float64 {
+	n := len(p.errs)
+	f := p.float(field, value, true)
+	switch {
+	case len(p.errs) > n:
+	case f == 0:
+		p.fail(field, value, errMissing, true)
+	case f < -limit || f > limit:
+		p.fail(field, value, errOutOfRange, true)
+		return 0
+	}
+	return f
+}
+
+// time parses an API timestamp. The API's "0000-00-00 00:00:00" counts as
+// empty.
+func (p *parser) time(field, value string, required bool) time.Time {
+	if strings.HasPrefix(value, "0000-00-00") {
+		value = ""
+	}
+	if !p.present(field, value, required) {
+		return time.Time{}
+	}
+	loc := p.loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation(TimeLayout, strings.TrimSpace(value), loc)
+	if err != nil {
+		p.fail(field, value, err, required)
+	}
+	return t
+}
+
+func (p *parser) onOff(field, value string) bool {
+	switch value {
+	case "ON":
+		return true
+	case "OFF", "":
+		return false
+	}
+	p.fail(field, value, errOnOff, false)
+	return false
+}
+
+func (p *parser) bool(field, value string) bool {
+	if !p.present(field, value, false) {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(field, value, unwrapNumError(err), false)
+	}
+	return b
+}
+
+// zeroAsEmpty maps the API's "0" placeholder to an empty string.
+func zeroAsEmpty(s string) string {
+	if s == "0" {
+		return ""
+	}
+	return s
+}
+
+// unwrapNumError drops the function name and input that strconv puts in its
+// errors, since FieldError already reports the value.
+func unwrapNumError(err error) error {
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		return numErr.Err
+	}
+	return err
+}
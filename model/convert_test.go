@@ -0,0 +1,100 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"TMTU/tmt"
+)
+
+func routeDetail(seq, wp, name, lat, lon string) tmt.RouteDetail {
+	var d tmt.RouteDetail
+	d.SequenceNo = seq
+	d.WPointNo = wp
+	d.Waypoints.WPointNo = wp
+	d.Waypoints.WpointName = name
+	d.Waypoints.Latitude = lat
+	d.Waypoints.Longitude = lon
+	return d
+}
+
+func TestRouteFromAPI(t *testing.T) {
+	r := tmt.Route{RouteNo: 3, RouteNum: "12", RouteDirection: "UP", RouteDetails: []tmt.RouteDetail{
+		routeDetail("1", "101", " Thane Station (W) ", "19.1863", "72.9747"),
+		routeDetail("2", "102", "Gokhale Road", "", "72.9724"),
+		routeDetail("x", "103", "Naupada", "19.1950", "72.9700"),
+		routeDetail("4", "105", "Majiwada", "19.2180", "72.9760"),
+	}}
+	route, err := RouteFromAPI(r)
+
+	var errs FieldErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got %v, want FieldErrors", err)
+	}
+	want := []string{"route_details[1].waypoints.Latitude", "route_details[2].SequenceNo"}
+	if got := errs.Fields(); !reflect.DeepEqual(got, want) {
+		t.Errorf("failed fields %v, want %v", got, want)
+	}
+	if len(route.Stops) != 2 {
+		t.Fatalf("%d stops kept, want 2", len(route.Stops))
+	}
+	first := route.Stops[0]
+	if first.Sequence != 1 || first.Stop.No != 101 || first.Stop.Name != "Thane Station (W)" ||
+		!reflect.DeepEqual(first.Stop.Location.Coordinates, []float64{72.9747, 19.1863}) {
+		t.Errorf("first stop %+v", first)
+	}
+	if route.Stops[1].Stop.No != 105 {
+		t.Errorf("second stop is %d, want 105", route.Stops[1].Stop.No)
+	}
+	if route.No != 3 || route.Num != "12" || route.Direction != "UP" {
+		t.Errorf("route %+v", route)
+	}
+
+	if _, err := RouteFromAPI(tmt.Route{RouteDetails: r.RouteDetails[:1]}); err != nil {
+		t.Errorf("valid route: %v", err)
+	}
+}
+
+func TestPositionFromAPI(t *testing.T) {
+	loc := time.FixedZone("IST", 5*3600+1800)
+	b := tmt.BusLocation{
+		VehID:       "211",
+		LastTrackdt: "2023-11-20 10:15:32",
+		Latitude:    "19.1863",
+		Longitude:   "72.9747",
+		Speed:       "fast",
+		AC:          "ON",
+	}
+	p, err := PositionFromAPI(b, loc)
+	var errs FieldErrors
+	if !errors.As(err, &errs) || errs.Rejected() {
+		t.Fatalf("got %v, want FieldErrors that are not Rejected", err)
+	}
+	if !reflect.DeepEqual(p.Invalid, []string{"Speed"}) {
+		t.Errorf("Invalid = %v", p.Invalid)
+	}
+	if p.ID != 211 || !p.AC || !p.LastTrackdt.Equal(time.Date(2023, 11, 20, 4, 45, 32, 0, time.UTC)) {
+		t.Errorf("position %+v", p)
+	}
+
+	b.LastTrackdt = "0000-00-00 00:00:00"
+	if _, err := PositionFromAPI(b, loc); !errors.As(err, &errs) || !errs.Rejected() {
+		t.Errorf("no LastTrackdt: got %v, want a rejection", err)
+	}
+}
+
+func TestCoordinateZero(t *testing.T) {
+	for _, v := range []string{"0", "0.0", "-0.000"} {
+		p := parser{}
+		p.coordinate("Latitude", v, 90)
+		if len(p.errs) != 1 || p.errs[0].Err != errMissing || !p.errs[0].Required {
+			t.Errorf("%q: errors %v, want missing", v, p.errs)
+		}
+	}
+	p := parser{}
+	if f := p.coordinate("Latitude", "19.1863", 90); len(p.errs) != 0 || f != 19.1863 {
+		t.Errorf("19.1863: %v, %v", f, p.errs)
+	}
+}
@@ -0,0 +1,92 @@
+// Package model holds the canonical types of the Thane bus network, separate
+// from the wire format of the TMT API in package tmt.
+package model
+
+import "time"
+
+// Stop is a bus stop (a "waypoint" in the API).
+type Stop struct {
+	No        int
+	Name      string
+	NameEng   string
+	GroupType string
+	Location  Point
+}
+
+// Route is one direction of a bus route with its stops in order.
+type Route struct {
+	No        int    // RouteNo, the API's key for the route
+	Num       string // RouteNum, the number shown on the bus
+	Name      string
+	Direction string
+	Stops     []RouteStop
+}
+
+// RouteStop is a stop at a position along a route.
+type RouteStop struct {
+	Sequence int
+	Stop     Stop
+}
+
+// Vehicle identifies a bus.
+type Vehicle struct {
+	ID int    `bson:"VehId" json:"VehId"`
+	No string `bson:"VehNo" json:"VehNo"` // registration number
+}
+
+// Position is one tracked position of a bus as stored in the database. The
+// JSON names match the BSON ones.
+// Fields that could not be parsed but did not make the record unusable are
+// left at their zero value and named in Invalid.
+type Position struct {
+	IdxTrackidPk     int `bson:"idx_Trackid_pk" json:"idx_Trackid_pk"`
+	Vehicle          `bson:",inline"`
+	CmpID            int         `bson:"CmpId" json:"CmpId"`
+	LastTrackdt      time.Time   `bson:"LastTrackdt" json:"LastTrackdt"`
+	NCSent           string      `bson:"NCSent,omitempty" json:"NCSent,omitempty"`
+	CSent            string      `bson:"CSent,omitempty" json:"CSent,omitempty"`
+	PrevTrackDt      time.Time   `bson:"PrevTrackDt,omitempty" json:"PrevTrackDt,omitempty"`
+	LastNCSentDate   time.Time   `bson:"LastNCSentDate,omitempty" json:"LastNCSentDate,omitempty"`
+	City             interface{} `bson:"City,omitempty" json:"City,omitempty"`
+	Speed            float64     `bson:"Speed" json:"Speed"`
+	ImagePath        interface{} `bson:"ImagePath,omitempty" json:"ImagePath,omitempty"`
+	AC               bool        `bson:"AC" json:"AC"`
+	Ignition         bool        `bson:"Ignition" json:"Ignition"`
+	AUX1             bool        `bson:"AUX1" json:"AUX1"`
+	DI4              bool        `bson:"DI4" json:"DI4"`
+	Fuel             float64     `bson:"Fuel,omitempty" json:"Fuel,omitempty"`
+	Temparature      string      `bson:"Temperature,omitempty" json:"Temperature,omitempty"`
+	WPointNo         int         `bson:"WPointNo,omitempty" json:"WPointNo,omitempty"`
+	Odometer         float64     `bson:"Odometer" json:"Odometer"`
+	Distance         float64     `bson:"Distance" json:"Distance"`
+	ETATime          float64     `bson:"ETATime" json:"ETATime"`
+	ETARoute         string      `bson:"ETARoute,omitempty" json:"ETARoute,omitempty"`
+	ETAOldTime       float64     `bson:"ETAOldTime" json:"ETAOldTime"`
+	Routeflag        bool        `bson:"routeflag" json:"routeflag"`
+	ETARouteName     string      `bson:"ETARouteName" json:"ETARouteName"`
+	DirectionFrom    string      `bson:"DirectionFrom" json:"DirectionFrom"`
+	DirectionTo      string      `bson:"DirectionTo" json:"DirectionTo"`
+	DispatchDateTime time.Time   `bson:"DispatchDateTime,omitempty" json:"DispatchDateTime,omitempty"`
+	ETATime1         float64     `bson:"ETATime1" json:"ETATime1"`
+	ETAOldTime1      float64     `bson:"ETAOldTime1" json:"ETAOldTime1"`
+	Routeflag1       int         `bson:"routeflag1,omitempty" json:"routeflag1,omitempty"`
+	RouteNo          int         `bson:"RouteNo" json:"RouteNo"`
+	WaybillNo        int         `bson:"WaybillNo" json:"WaybillNo"`
+	Lastwaypointid   int         `bson:"lastwaypointid,omitempty" json:"lastwaypointid,omitempty"`
+	Token            int         `bson:"token" json:"token"`
+	Avgspeed         float64     `bson:"avgspeed" json:"avgspeed"`
+	Location         Point       `bson:"location" json:"location"`
+	Invalid          []string    `bson:"invalid,omitempty" json:"invalid,omitempty"` // API fields that failed to parse
+	TZ               string      `bson:"tz,omitempty" json:"tz,omitempty"`           // zone the API timestamps were read in
+}
+
+// Point is a GeoJSON point.
+type Point struct {
+	Type        string    `bson:"type" json:"type"`
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"` // longitude, latitude
+}
+
+// NewPoint returns the GeoJSON point at lon, lat.
+func NewPoint(lon, lat float64) Point {
+	return Point{Type: "Point", Coordinates: []float64{lon, lat}}
+}
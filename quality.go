@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"TMTU/model"
+)
+
+// qualityReport counts the conversion failures of the tracking feed so that
+// format changes upstream show up. It is written as JSON to TMTQuality.json.
+type qualityReport struct {
+	Since    time.Time                  `json:"since"`
+	Updated  time.Time                  `json:"updated"`
+	Records  int                        `json:"records"`  // positions converted
+	Rejected int                        `json:"rejected"` // not stored, a required field failed
+	Flagged  int                        `json:"flagged"`  // stored with model.Position.Invalid set
+	Fields   map[string]*fieldQuality   `json:"fields"`   // by API field name
+	Vehicles map[string]*vehicleQuality `json:"vehicles"` // by VehId
+}
+
+type fieldQuality struct {
+	Failures  int    `json:"failures"`
+	LastValue string `json:"last_value"`
+	LastError string `json:"last_error"`
+}
+
+type vehicleQuality struct {
+	Records  int            `json:"records"`
+	Rejected int            `json:"rejected"`
+	Flagged  int            `json:"flagged"`
+	Fields   map[string]int `json:"fields"`
+}
+
+func newQualityReport() *qualityReport {
+	now := time.Now()
+	return &qualityReport{
+		Since:    now,
+		Updated:  now,
+		Fields:   make(map[string]*fieldQuality),
+		Vehicles: make(map[string]*vehicleQuality),
+	}
+}
+
+// add records the result of converting the position of vehID.
+func (q *qualityReport) add(vehID string, err error) {
+	q.Records++
+	v := q.Vehicles[vehID]
+	if v == nil {
+		v = &vehicleQuality{Fields: make(map[string]int)}
+		q.Vehicles[vehID] = v
+	}
+	v.Records++
+
+	var fieldErrs model.FieldErrors
+	if !errors.As(err, &fieldErrs) {
+		return
+	}
+	if fieldErrs.Rejected() {
+		q.Rejected++
+		v.Rejected++
+	} else {
+		q.Flagged++
+		v.Flagged++
+	}
+	for _, e := range fieldErrs {
+		f := q.Fields[e.Field]
+		if f == nil {
+			f = new(fieldQuality)
+			q.Fields[e.Field] = f
+		}
+		f.Failures++
+		f.LastValue = e.Value
+		f.LastError = e.Err.Error()
+		v.Fields[e.Field]++
+	}
+}
+
+// summary returns a one-line overview, e.g.
+// "Data quality: 1200 positions, 3 rejected, 41 flagged (Odometer 40, LastTrackdt 3)".
+func (q *qualityReport) summary() string {
+	names := make([]string, 0, len(q.Fields))
+	for name := range q.Fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		fi, fj := q.Fields[names[i]].Failures, q.Fields[names[j]].Failures
+		if fi != fj {
+			return fi > fj
+		}
+		return names[i] < names[j]
+	})
+	counts := make([]string, len(names))
+	for i, name := range names {
+		counts[i] = fmt.Sprintf("%s %d", name, q.Fields[name].Failures)
+	}
+
+	s := fmt.Sprintf("Data quality: %d positions, %d rejected, %d flagged", q.Records, q.Rejected, q.Flagged)
+	if len(counts) > 0 {
+		s += " (" + strings.Join(counts, ", ") + ")"
+	}
+	return s
+}
+
+// write saves the report to path.
+func (q *qualityReport) write(path string) error {
+	q.Updated = time.Now()
+	b, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
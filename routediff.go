@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"TMTU/tmt"
+)
+
+// routeChangeLog is the result of comparing two crawls. route-diff appends
+// one per run to a JSON Lines file so route changes can be followed over
+// time.
+type routeChangeLog struct {
+	ComparedAt time.Time   `json:"compared_at"`
+	Old        string      `json:"old"`
+	New        string      `json:"new"`
+	Threshold  float64     `json:"threshold_m"` // least distance reported as a move
+	Routes     []routeDiff `json:"routes"`      // routes that differ, by RouteNo
+	Unchanged  int         `json:"unchanged"`
+}
+
+// routeDiff is how one route differs between two crawls.
+type routeDiff struct {
+	RouteNo        int          `json:"route_no"`
+	RouteNum       string       `json:"route_num"`
+	RouteDirection string       `json:"route_direction"`
+	Status         string       `json:"status"` // added, removed or changed
+	Stops          int          `json:"stops"`  // in the newer crawl, or the older one if removed
+	Changes        []stopChange `json:"changes,omitempty"`
+}
+
+// stopChange is one difference in the stops of a route.
+type stopChange struct {
+	Kind     string    `json:"kind"` // added, removed, moved, renamed or reordered
+	WPointNo string    `json:"wpoint_no"`
+	Name     string    `json:"name"`
+	OldName  string    `json:"old_name,omitempty"`
+	OldSeq   string    `json:"old_sequence_no,omitempty"`
+	NewSeq   string    `json:"new_sequence_no,omitempty"`
+	OldCoord []float64 `json:"old_coordinates,omitempty"` // longitude, latitude
+	NewCoord []float64 `json:"new_coordinates,omitempty"`
+	Distance float64   `json:"distance_m,omitempty"`
+}
+
+// routeDiffCmd compares the crawls at oldPath and newPath, prints the
+// report and appends the change log to logPath; "-" prints the change log
+// instead of the report and "" skips it.
+func routeDiffCmd(oldPath, newPath string, threshold float64, logPath string) error {
+	oldRoutes, err := loadCrawl(oldPath)
+	if err != nil {
+		return err
+	}
+	newRoutes, err := loadCrawl(newPath)
+	if err != nil {
+		return err
+	}
+	changeLog := diffCrawls(oldRoutes, newRoutes, threshold)
+	changeLog.ComparedAt, changeLog.Old, changeLog.New = time.Now(), oldPath, newPath
+
+	b, err := json.Marshal(changeLog)
+	if err != nil {
+		return err
+	}
+	switch logPath {
+	case "-":
+		fmt.Printf("%s\n", b)
+		return nil
+	case "":
+	default:
+		f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(b, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	printRouteChanges(os.Stdout, changeLog)
+	return nil
+}
+
+// loadCrawl reads the raw getRouteDetailsNew responses of a crawl from a
+// directory, as written by "routes -raw" or kept in TMTRoutesCrawl, or from
+// single files. Other files, such as GeoJSON route files, are skipped.
+func loadCrawl(path string) (map[int]tmt.Route, error) {
+	names := []string{path}
+	if fi, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		if names, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+	}
+	routes := make(map[int]tmt.Route)
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var resp tmt.ResponseRouteNo
+		if json.Unmarshal(b, &resp) != nil || len(resp.Data) == 0 {
+			continue
+		}
+		routes[resp.Data[0].RouteNo] = resp.Data[0]
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("%s: no raw route files (crawl with routes -raw)", path)
+	}
+	return routes, nil
+}
+
+// diffCrawls compares every route of two crawls by RouteNo.
+func diffCrawls(oldRoutes, newRoutes map[int]tmt.Route, threshold float64) routeChangeLog {
+	changeLog := routeChangeLog{Threshold: threshold, Routes: []routeDiff{}}
+	nos := make([]int, 0, len(oldRoutes)+len(newRoutes))
+	for no := range oldRoutes {
+		nos = append(nos, no)
+	}
+	for no := range newRoutes {
+		if _, ok := oldRoutes[no]; !ok {
+			nos = append(nos, no)
+		}
+	}
+	sort.Ints(nos)
+
+	for _, no := range nos {
+		o, inOld := oldRoutes[no]
+		n, inNew := newRoutes[no]
+		switch {
+		case !inOld:
+			changeLog.Routes = append(changeLog.Routes, routeDiff{RouteNo: no, RouteNum: n.RouteNum, RouteDirection: n.RouteDirection,
+				Status: "added", Stops: len(n.RouteDetails)})
+		case !inNew:
+			changeLog.Routes = append(changeLog.Routes, routeDiff{RouteNo: no, RouteNum: o.RouteNum, RouteDirection: o.RouteDirection,
+				Status: "removed", Stops: len(o.RouteDetails)})
+		default:
+			changes := diffRouteStops(o, n, threshold)
+			if len(changes) == 0 {
+				changeLog.Unchanged++
+				continue
+			}
+			changeLog.Routes = append(changeLog.Routes, routeDiff{RouteNo: no, RouteNum: n.RouteNum, RouteDirection: n.RouteDirection,
+				Status: "changed", Stops: len(n.RouteDetails), Changes: changes})
+		}
+	}
+	return changeLog
+}
+
+// routeStop is a stop of a route with what diffRouteStops compares.
+type routeStop struct {
+	key   string // WPointNo, with the visit number for stops served twice
+	wp    string
+	name  string
+	seq   string
+	coord []float64 // nil if the coordinates do not parse
+}
+
+// routeStops returns the stops of r in the order of their SequenceNo. The
+// API does not always list route_details in that order. Details without a
+// numeric SequenceNo follow in the order they are listed.
+func routeStops(r tmt.Route) []routeStop {
+	details := make([]tmt.RouteDetail, len(r.RouteDetails))
+	copy(details, r.RouteDetails)
+	seq := func(d tmt.RouteDetail) (int, bool) {
+		n, err := strconv.Atoi(strings.TrimSpace(d.SequenceNo))
+		return n, err == nil
+	}
+	sort.SliceStable(details, func(i, j int) bool {
+		a, aok := seq(details[i])
+		b, bok := seq(details[j])
+		return aok && (!bok || a < b)
+	})
+
+	visits := make(map[string]int)
+	stops := make([]routeStop, len(details))
+	for i, d := range details {
+		wp := d.Waypoints.WPointNo
+		if wp == "" {
+			wp = d.WPointNo
+		}
+		visits[wp]++
+		key := wp
+		if visits[wp] > 1 {
+			key = fmt.Sprintf("%s#%d", wp, visits[wp])
+		}
+		s := routeStop{key: key, wp: wp, name: strings.TrimSpace(d.Waypoints.WpointName), seq: d.SequenceNo}
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(d.Waypoints.Latitude), 64)
+		lon, errLon := strconv.ParseFloat(strings.TrimSpace(d.Waypoints.Longitude), 64)
+		if errLat == nil && errLon == nil {
+			s.coord = []float64{lon, lat}
+		}
+		stops[i] = s
+	}
+	return stops
+}
+
+// diffRouteStops lists the stop changes from o to n: stops removed, then in
+// the order of n stops added, moved further than threshold metres, renamed
+// and reordered. A stop counts as reordered when it is not part of the
+// longest run of stops kept in the same relative order, so a stop inserted
+// in the middle does not make all later stops reordered.
+func diffRouteStops(o, n tmt.Route, threshold float64) []stopChange {
+	oldStops, newStops := routeStops(o), routeStops(n)
+	oldByKey := make(map[string]routeStop, len(oldStops))
+	for _, s := range oldStops {
+		oldByKey[s.key] = s
+	}
+	newByKey := make(map[string]bool, len(newStops))
+	for _, s := range newStops {
+		newByKey[s.key] = true
+	}
+
+	var changes []stopChange
+	var oldCommon, newCommon []string
+	for _, s := range oldStops {
+		if !newByKey[s.key] {
+			changes = append(changes, stopChange{Kind: "removed", WPointNo: s.wp, Name: s.name, OldSeq: s.seq, OldCoord: s.coord})
+		} else {
+			oldCommon = append(oldCommon, s.key)
+		}
+	}
+	for _, s := range newStops {
+		if _, ok := oldByKey[s.key]; ok {
+			newCommon = append(newCommon, s.key)
+		}
+	}
+	inOrder := longestCommonSubsequence(oldCommon, newCommon)
+
+	for _, s := range newStops {
+		prev, ok := oldByKey[s.key]
+		if !ok {
+			changes = append(changes, stopChange{Kind: "added", WPointNo: s.wp, Name: s.name, NewSeq: s.seq, NewCoord: s.coord})
+			continue
+		}
+		if prev.coord != nil && s.coord != nil {
+			if d := distance(prev.coord, s.coord); d > threshold {
+				changes = append(changes, stopChange{Kind: "moved", WPointNo: s.wp, Name: s.name,
+					OldCoord: prev.coord, NewCoord: s.coord, Distance: math.Round(d)})
+			}
+		}
+		if prev.name != s.name {
+			changes = append(changes, stopChange{Kind: "renamed", WPointNo: s.wp, Name: s.name, OldName: prev.name})
+		}
+		if !inOrder[s.key] {
+			changes = append(changes, stopChange{Kind: "reordered", WPointNo: s.wp, Name: s.name, OldSeq: prev.seq, NewSeq: s.seq})
+		}
+	}
+	return changes
+}
+
+// longestCommonSubsequence returns the keys of a longest sequence that a
+// and b both contain in the same order.
+func longestCommonSubsequence(a, b []string) map[string]bool {
+	l := make([][]int, len(a)+1)
+	for i := range l {
+		l[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				l[i][j] = l[i+1][j+1] + 1
+			case l[i+1][j] >= l[i][j+1]:
+				l[i][j] = l[i+1][j]
+			default:
+				l[i][j] = l[i][j+1]
+			}
+		}
+	}
+	keep := make(map[string]bool)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			keep[a[i]] = true
+			i++
+			j++
+		case l[i+1][j] >= l[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return keep
+}
+
+// distance returns the great-circle distance in metres between two
+// longitude, latitude pairs.
+func distance(a, b []float64) float64 {
+	const earthRadius = 6371000 // metres
+	rad := math.Pi / 180
+	dLat := (b[1] - a[1]) * rad
+	dLon := (b[0] - a[0]) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a[1]*rad)*math.Cos(b[1]*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func printRouteChanges(w io.Writer, changeLog routeChangeLog) {
+	var added, removed, changed int
+	for _, r := range changeLog.Routes {
+		title := fmt.Sprintf("Route %s %s (RouteNo %d)", r.RouteNum, r.RouteDirection, r.RouteNo)
+		switch r.Status {
+		case "added":
+			added++
+			fmt.Fprintf(w, "%s: added, %d stops\n", title, r.Stops)
+			continue
+		case "removed":
+			removed++
+			fmt.Fprintf(w, "%s: removed, had %d stops\n", title, r.Stops)
+			continue
+		}
+		changed++
+		fmt.Fprintf(w, "%s: %d changes\n", title, len(r.Changes))
+		for _, c := range r.Changes {
+			switch c.Kind {
+			case "added":
+				fmt.Fprintf(w, "  + %s %s added as SequenceNo %s\n", c.WPointNo, c.Name, c.NewSeq)
+			case "removed":
+				fmt.Fprintf(w, "  - %s %s removed, was SequenceNo %s\n", c.WPointNo, c.Name, c.OldSeq)
+			case "moved":
+				fmt.Fprintf(w, "  > %s %s moved %.0f m\n", c.WPointNo, c.Name, c.Distance)
+			case "renamed":
+				fmt.Fprintf(w, "  ~ %s renamed from %q to %q\n", c.WPointNo, c.OldName, c.Name)
+			case "reordered":
+				fmt.Fprintf(w, "  ^ %s %s reordered, SequenceNo %s -> %s\n", c.WPointNo, c.Name, c.OldSeq, c.NewSeq)
+			}
+		}
+	}
+	fmt.Fprintf(w, "Routes: %d added, %d removed, %d changed, %d unchanged\n", added, removed, changed, changeLog.Unchanged)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"TMTU/tmt"
+)
+
+// testRoute returns a route with a stop per entry of stops, "WPointNo name
+// lat lon", in that order and numbered from 1.
+func testRoute(no int, stops ...[4]string) tmt.Route {
+	r := tmt.Route{RouteNo: no, RouteNum: "12", RouteDirection: "UP"}
+	for i, s := range stops {
+		var d tmt.RouteDetail
+		d.SequenceNo = strconv.Itoa(i + 1)
+		d.WPointNo = s[0]
+		d.Waypoints.WPointNo = s[0]
+		d.Waypoints.WpointName = s[1]
+		d.Waypoints.Latitude = s[2]
+		d.Waypoints.Longitude = s[3]
+		r.RouteDetails = append(r.RouteDetails, d)
+	}
+	return r
+}
+
+var (
+	stopA = [4]string{"101", "Thane Station (W)", "19.1863", "72.9747"}
+	stopB = [4]string{"102", "Gokhale Road", "19.1921", "72.9724"}
+	stopC = [4]string{"103", "Naupada", "19.1950", "72.9700"}
+	stopD = [4]string{"104", "Majiwada", "19.2180", "72.9760"}
+)
+
+func changeKinds(changes []stopChange) []string {
+	var kinds []string
+	for _, c := range changes {
+		kinds = append(kinds, c.Kind+" "+c.WPointNo)
+	}
+	return kinds
+}
+
+func TestDiffRouteStops(t *testing.T) {
+	moved := stopB
+	moved[2] = "19.1930" // about 100 m north
+	nudged := stopB
+	nudged[2] = "19.1922" // about 11 m
+	renamed := stopC
+	renamed[1] = "Naupada Police Station"
+
+	for _, tc := range []struct {
+		name     string
+		old, new tmt.Route
+		want     []string
+	}{
+		{"same", testRoute(1, stopA, stopB, stopC), testRoute(1, stopA, stopB, stopC), nil},
+		{"added in the middle", testRoute(1, stopA, stopC, stopD), testRoute(1, stopA, stopB, stopC, stopD),
+			[]string{"added 102"}},
+		{"removed", testRoute(1, stopA, stopB, stopC), testRoute(1, stopA, stopC),
+			[]string{"removed 102"}},
+		{"moved", testRoute(1, stopA, stopB), testRoute(1, stopA, moved),
+			[]string{"moved 102"}},
+		{"moved less than the threshold", testRoute(1, stopA, stopB), testRoute(1, stopA, nudged), nil},
+		{"renamed", testRoute(1, stopA, stopC), testRoute(1, stopA, renamed),
+			[]string{"renamed 103"}},
+		{"one stop reordered", testRoute(1, stopA, stopB, stopC, stopD), testRoute(1, stopA, stopC, stopD, stopB),
+			[]string{"reordered 102"}},
+		{"removed and added", testRoute(1, stopA, stopB), testRoute(1, stopA, stopD),
+			[]string{"removed 102", "added 104"}},
+	} {
+		got := changeKinds(diffRouteStops(tc.old, tc.new, 25))
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestDiffRouteStopsSequenceNo checks that stops are compared in the order
+// of their SequenceNo, not of route_details.
+func TestDiffRouteStopsSequenceNo(t *testing.T) {
+	old := testRoute(1, stopA, stopB, stopC, stopD)
+	renumbered := testRoute(1, stopA, stopB, stopC, stopD)
+	for i, seq := range []string{"1", "4", "2", "3"} {
+		renumbered.RouteDetails[i].SequenceNo = seq
+	}
+	got := changeKinds(diffRouteStops(old, renumbered, 25))
+	if !reflect.DeepEqual(got, []string{"reordered 102"}) {
+		t.Errorf("only SequenceNo changed: %v, want 102 reordered", got)
+	}
+
+	// Listed in another order but with the same SequenceNo values.
+	shuffled := testRoute(1, stopA, stopB, stopC, stopD)
+	d := shuffled.RouteDetails
+	d[0], d[3] = d[3], d[0]
+	if changes := diffRouteStops(old, shuffled, 25); len(changes) != 0 {
+		t.Errorf("only the listing order changed: %v", changeKinds(changes))
+	}
+}
+
+func TestDiffRouteStopsDetails(t *testing.T) {
+	moved := stopB
+	moved[2] = "19.1930"
+	changes := diffRouteStops(testRoute(1, stopA, stopB), testRoute(1, stopA, moved), 25)
+	if len(changes) != 1 {
+		t.Fatalf("%d changes", len(changes))
+	}
+	c := changes[0]
+	if c.Distance < 90 || c.Distance > 110 {
+		t.Errorf("moved %v m, want about 100", c.Distance)
+	}
+	if !reflect.DeepEqual(c.OldCoord, []float64{72.9724, 19.1921}) || !reflect.DeepEqual(c.NewCoord, []float64{72.9724, 19.1930}) {
+		t.Errorf("coordinates %v -> %v", c.OldCoord, c.NewCoord)
+	}
+}
+
+func TestDiffRouteStopsServedTwice(t *testing.T) {
+	// A loop route passing stop A at the start and the end.
+	old := testRoute(1, stopA, stopB, stopC, stopA)
+	changes := diffRouteStops(old, testRoute(1, stopA, stopB, stopC, stopA), 25)
+	if len(changes) != 0 {
+		t.Errorf("unchanged loop route: %v", changeKinds(changes))
+	}
+	got := changeKinds(diffRouteStops(old, testRoute(1, stopA, stopB, stopC), 25))
+	if !reflect.DeepEqual(got, []string{"removed 101"}) {
+		t.Errorf("second visit removed: %v", got)
+	}
+}
+
+func TestLongestCommonSubsequence(t *testing.T) {
+	for _, tc := range []struct {
+		a, b []string
+		want []string
+	}{
+		{nil, nil, nil},
+		{[]string{"a", "b", "c"}, []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{[]string{"a", "b", "c", "d"}, []string{"a", "c", "d", "b"}, []string{"a", "c", "d"}},
+		{[]string{"a", "b", "c"}, []string{"c", "b", "a"}, []string{"a"}},
+		{[]string{"a", "b"}, []string{"c", "d"}, nil},
+	} {
+		keep := longestCommonSubsequence(tc.a, tc.b)
+		var got []string
+		for _, k := range tc.a {
+			if keep[k] {
+				got = append(got, k)
+			}
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%v %v: kept %v, want %d keys like %v", tc.a, tc.b, got, len(tc.want), tc.want)
+			continue
+		}
+		if len(tc.want) > 1 && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v %v: kept %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestDiffCrawls(t *testing.T) {
+	old := map[int]tmt.Route{1: testRoute(1, stopA, stopB), 2: testRoute(2, stopA), 3: testRoute(3, stopC)}
+	new := map[int]tmt.Route{1: testRoute(1, stopA, stopB), 3: testRoute(3, stopC, stopD), 4: testRoute(4, stopD)}
+	changeLog := diffCrawls(old, new, 25)
+
+	var got []string
+	for _, r := range changeLog.Routes {
+		got = append(got, string(rune('0'+r.RouteNo))+" "+r.Status)
+	}
+	if want := []string{"2 removed", "3 changed", "4 added"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("routes %v, want %v", got, want)
+	}
+	if changeLog.Unchanged != 1 {
+		t.Errorf("%d unchanged, want 1", changeLog.Unchanged)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// Thane station to Mumbai CST, about 30 km.
+	d := distance([]float64{72.9747, 19.1863}, []float64{72.8355, 18.9398})
+	if d < 30000 || d > 32000 {
+		t.Errorf("distance %.0f m", d)
+	}
+	if d := distance([]float64{72.97, 19.18}, []float64{72.97, 19.18}); d != 0 {
+		t.Errorf("distance to itself %v", d)
+	}
+}
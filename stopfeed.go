@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"TMTU/model"
+	"TMTU/tmt"
+)
+
+// maxRejectedShown limits the rejected stops listed on the console; all of
+// them are in TMTStopsRejected.json.
+const maxRejectedShown = 20
+
+// validStop is a getWayPoints entry that passed validateStops.
+type validStop struct {
+	raw  tmt.Waypoint
+	stop model.Stop
+}
+
+// rejectedStop is a getWayPoints entry left out of TMTStopsDirect.json.
+type rejectedStop struct {
+	Index    int      `json:"index"` // position in the getWayPoints data
+	WPointNo string   `json:"WPointNo"`
+	Name     string   `json:"WpointName"`
+	Reasons  []string `json:"reasons"`
+	fields   []string // for the summary
+}
+
+// validateStops converts the getWayPoints entries and returns the usable
+// stops and the rejected entries: those without a name, without valid
+// coordinates or WPointNo, and all but the first entry of a WPointNo.
+func validateStops(entries []tmt.Waypoint) ([]validStop, []rejectedStop) {
+	var (
+		stops    []validStop
+		rejected []rejectedStop
+		first    = make(map[int]int) // WPointNo -> index of its first entry
+	)
+	for i, w := range entries {
+		stop, err := model.StopFromAPI(w)
+		if err != nil {
+			r := rejectedStop{Index: i, WPointNo: w.WPointNo, Name: w.WpointName}
+			var errs model.FieldErrors
+			if errors.As(err, &errs) {
+				for _, e := range errs {
+					r.Reasons = append(r.Reasons, e.Error())
+				}
+				r.fields = errs.Fields()
+			} else {
+				r.Reasons, r.fields = []string{err.Error()}, []string{"other"}
+			}
+			rejected = append(rejected, r)
+			continue
+		}
+		if j, dup := first[stop.No]; dup {
+			rejected = append(rejected, rejectedStop{Index: i, WPointNo: w.WPointNo, Name: w.WpointName,
+				Reasons: []string{fmt.Sprintf("duplicate WPointNo, first at index %d (%q)", j, entries[j].WpointName)},
+				fields:  []string{"duplicate WPointNo"}})
+			continue
+		}
+		first[stop.No] = i
+		stops = append(stops, validStop{raw: w, stop: stop})
+	}
+	return stops, rejected
+}
+
+// rejectedSummary counts the rejected stops by failing field, e.g.
+// "3 rejected (Latitude 2, duplicate WPointNo 1)".
+func rejectedSummary(rejected []rejectedStop) string {
+	counts := make(map[string]int)
+	for _, r := range rejected {
+		for _, f := range r.fields {
+			counts[f]++
+		}
+	}
+	fields := make([]string, 0, len(counts))
+	for f := range counts {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		if counts[fields[i]] != counts[fields[j]] {
+			return counts[fields[i]] > counts[fields[j]]
+		}
+		return fields[i] < fields[j]
+	})
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = fmt.Sprintf("%s %d", f, counts[f])
+	}
+	return fmt.Sprintf("%d rejected (%s)", len(rejected), strings.Join(parts, ", "))
+}
+
+// printRejectedStops prints the summary and the first rejected entries and
+// writes all of them to path.
+func printRejectedStops(rejected []rejectedStop, total int, path string) error {
+	fmt.Printf("Stops: %d of %d saved, %s\n", total-len(rejected), total, rejectedSummary(rejected))
+	for i, r := range rejected {
+		if i == maxRejectedShown {
+			fmt.Printf("  ... and %d more, see %s\n", len(rejected)-i, path)
+			break
+		}
+		fmt.Printf("  #%d WPointNo %q %q: %s\n", r.Index, r.WPointNo, r.Name, strings.Join(r.Reasons, "; "))
+	}
+	b, err := json.MarshalIndent(rejected, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
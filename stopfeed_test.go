@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"TMTU/tmt"
+)
+
+func TestValidateStops(t *testing.T) {
+	entries := []tmt.Waypoint{
+		{WPointNo: "101", WpointName: "Thane Station (W)", Latitude: "19.1863", Longitude: "72.9747"},
+		{WPointNo: "102", WpointName: " ", Latitude: "19.1921", Longitude: "72.9724"},
+		{WPointNo: "103", WpointName: "Naupada", Latitude: "0", Longitude: "0.0"},
+		{WPointNo: "104", WpointName: "Majiwada", Latitude: "91", Longitude: "72.9760"},
+		{WPointNo: "105", WpointName: "Kopri", Latitude: "", Longitude: "72.98"},
+		{WPointNo: "101", WpointName: "Thane Station (E)", Latitude: "19.1860", Longitude: "72.9780"},
+		{WPointNo: "x", WpointName: "Teen Hath Naka", Latitude: "19.1960", Longitude: "72.9610"},
+		{WPointNo: "106", WpointName: "Vandana", Latitude: "19.1900", Longitude: "72.9650"},
+	}
+	stops, rejected := validateStops(entries)
+
+	var kept []string
+	for _, s := range stops {
+		kept = append(kept, s.stop.Name)
+	}
+	if want := []string{"Thane Station (W)", "Vandana"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if c := stops[0].stop.Location.Coordinates; !reflect.DeepEqual(c, []float64{72.9747, 19.1863}) {
+		t.Errorf("coordinates %v", c)
+	}
+
+	var got [][]string
+	for _, r := range rejected {
+		got = append(got, append([]string{r.WPointNo}, r.fields...))
+	}
+	want := [][]string{
+		{"102", "WpointName"},
+		{"103", "Latitude", "Longitude"},
+		{"104", "Latitude"},
+		{"105", "Latitude"},
+		{"101", "duplicate WPointNo"},
+		{"x", "WPointNo"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rejected %v, want %v", got, want)
+	}
+	if r := rejected[4]; r.Index != 5 || r.Reasons[0] != `duplicate WPointNo, first at index 0 ("Thane Station (W)")` {
+		t.Errorf("duplicate %+v", r)
+	}
+
+	summary := "6 rejected (Latitude 3, Longitude 1, WPointNo 1, WpointName 1, duplicate WPointNo 1)"
+	if s := rejectedSummary(rejected); s != summary {
+		t.Errorf("summary %q, want %q", s, summary)
+	}
+}
+
+func TestPrintRejectedStops(t *testing.T) {
+	_, rejected := validateStops([]tmt.Waypoint{{WPointNo: "103", WpointName: "Naupada", Latitude: "0", Longitude: "0"}})
+	path := filepath.Join(t.TempDir(), "TMTStopsRejected.json")
+	if err := printRejectedStops(rejected, 1, path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []rejectedStop
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].WPointNo != "103" || len(saved[0].Reasons) != 2 {
+		t.Errorf("saved %+v", saved)
+	}
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"TMTU/model"
+)
+
+// JSONL stores positions as JSON Lines in a directory: positions.jsonl is
+// only ever appended to and latest.json holds the latest position of every
+// vehicle. It suits small deployments and tests; Positions reads the whole
+// file.
+type JSONL struct {
+	dir string
+
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	seen   map[positionKey]bool
+	latest map[int]model.Position
+}
+
+const (
+	jsonlPositions = "positions.jsonl"
+	jsonlLatest    = "latest.json"
+)
+
+// OpenJSONL opens or creates the store in dir.
+func OpenJSONL(dir string) (*JSONL, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	s := &JSONL{
+		dir:    dir,
+		seen:   make(map[positionKey]bool),
+		latest: make(map[int]model.Position),
+	}
+	if err := trimPartialLine(filepath.Join(dir, jsonlPositions)); err != nil {
+		return nil, err
+	}
+	err := s.scan(func(p model.Position) error {
+		s.seen[keyOf(p)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, jsonlLatest)); err == nil {
+		if err := json.Unmarshal(b, &s.latest); err != nil {
+			return nil, fmt.Errorf("%s: %w", jsonlLatest, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	s.file, err = os.OpenFile(filepath.Join(dir, jsonlPositions), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.w = bufio.NewWriter(s.file)
+	return s, nil
+}
+
+// scan calls f for every stored position in file order.
+func (s *JSONL) scan(f func(model.Position) error) error {
+	file, err := os.Open(filepath.Join(s.dir, jsonlPositions))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var p model.Position
+		if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+			return fmt.Errorf("%s:%d: %w", jsonlPositions, line, err)
+		}
+		if err := f(p); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// trimPartialLine cuts off the end of name after its last newline. That
+// is what a crash while appending leaves behind; the positions of the cut
+// line were never reported as written, so they are still queued elsewhere.
+func trimPartialLine(name string) error {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := fi.Size()
+	end := size
+	buf := make([]byte, 64*1024)
+	for end > 0 {
+		n := int64(len(buf))
+		if n > end {
+			n = end
+		}
+		if _, err := f.ReadAt(buf[:n], end-n); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end += int64(i) + 1 - n
+			break
+		}
+		end -= n
+	}
+	if end == size {
+		return nil
+	}
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// WritePositions appends the positions not stored yet and syncs the file.
+// If that fails, e.g. on a full disk, the file is cut back to where it
+// ended before, so that no partial line stays in the middle of it.
+func (s *JSONL) WritePositions(_ context.Context, ps []model.Position) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fi, err := s.file.Stat()
+	if err != nil {
+		return Result{Failed: ps}, err
+	}
+	var (
+		res   Result
+		added []positionKey
+	)
+	for _, p := range ps {
+		k := keyOf(p)
+		if s.seen[k] {
+			res.Duplicates++
+			continue
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			res.Dropped++
+			continue
+		}
+		s.w.Write(b)
+		s.w.WriteByte('\n')
+		s.seen[k] = true
+		added = append(added, k)
+		res.Written++
+	}
+
+	err = s.w.Flush()
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		for _, k := range added {
+			delete(s.seen, k)
+		}
+		// A bufio.Writer keeps failing after its first error.
+		s.w = bufio.NewWriter(s.file)
+		if terr := s.file.Truncate(fi.Size()); terr != nil {
+			return Result{Failed: ps}, fmt.Errorf("%w; cutting off the partial write: %v", err, terr)
+		}
+		return Result{Failed: ps}, err
+	}
+	return res, nil
+}
+
+// UpsertLatest updates latest.json, replacing it atomically.
+func (s *JSONL) UpsertLatest(_ context.Context, ps []model.Position) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range ps {
+		if old, ok := s.latest[p.ID]; ok && old.LastTrackdt.After(p.LastTrackdt) {
+			continue
+		}
+		s.latest[p.ID] = p
+	}
+	b, err := json.Marshal(s.latest)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, jsonlLatest+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, jsonlLatest))
+}
+
+func (s *JSONL) Positions(_ context.Context, vehID int, from, to time.Time) ([]model.Position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	var ps []model.Position
+	err := s.scan(func(p model.Position) error {
+		if (vehID == 0 || p.ID == vehID) && !p.LastTrackdt.Before(from) && p.LastTrackdt.Before(to) {
+			ps = append(ps, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortPositions(ps)
+	return ps, nil
+}
+
+func (s *JSONL) Close(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"TMTU/model"
+)
+
+// position returns a position of vehicle id minute minutes into the test
+// day.
+func position(id, minute int) model.Position {
+	return model.Position{
+		Vehicle:     model.Vehicle{ID: id},
+		LastTrackdt: time.Date(2023, 11, 20, 10, minute, 0, 0, time.UTC),
+		Location:    model.NewPoint(72.97, 19.18),
+	}
+}
+
+func allPositions(t *testing.T, st Store) []model.Position {
+	t.Helper()
+	ps, err := st.Positions(context.Background(), 0, time.Time{}, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+func TestJSONL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st, err := OpenJSONL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := st.WritePositions(ctx, []model.Position{position(1, 0), position(2, 0), position(1, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Written != 2 || res.Duplicates != 1 {
+		t.Errorf("result %+v, want 2 written and 1 duplicate", res)
+	}
+	if err := st.UpsertLatest(ctx, []model.Position{position(1, 5), position(1, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened, the store still knows what it holds.
+	if st, err = OpenJSONL(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close(ctx)
+	if res, _ := st.WritePositions(ctx, []model.Position{position(2, 0)}); res.Duplicates != 1 {
+		t.Errorf("position stored before the restart: result %+v, want a duplicate", res)
+	}
+	if got := st.latest[1].LastTrackdt.Minute(); got != 5 {
+		t.Errorf("latest position of vehicle 1 at minute %d, want 5", got)
+	}
+	if ps := allPositions(t, st); len(ps) != 2 {
+		t.Errorf("%d positions, want 2", len(ps))
+	}
+}
+
+func TestJSONLPartialLine(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st, err := OpenJSONL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.WritePositions(ctx, []model.Position{position(1, 0), position(1, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	st.Close(ctx)
+
+	// A crash in the middle of an append.
+	name := filepath.Join(dir, jsonlPositions)
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"VehId":1,"LastTrackdt":"2023-11-20T10:0`)
+	f.Close()
+
+	if st, err = OpenJSONL(dir); err != nil {
+		t.Fatalf("reopening after a partial line: %v", err)
+	}
+	if _, err := st.WritePositions(ctx, []model.Position{position(1, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	st.Close(ctx)
+
+	if st, err = OpenJSONL(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close(ctx)
+	if ps := allPositions(t, st); len(ps) != 3 {
+		t.Errorf("%d positions, want 3", len(ps))
+	}
+}
+
+func TestTrimPartialLine(t *testing.T) {
+	for _, tc := range []struct{ content, want string }{
+		{"", ""},
+		{"a\n", "a\n"},
+		{"a\nb", "a\n"},
+		{"partial", ""},
+		{"a\nb\n\n", "a\nb\n\n"},
+	} {
+		name := filepath.Join(t.TempDir(), "f")
+		if err := os.WriteFile(name, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := trimPartialLine(name); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := os.ReadFile(name)
+		if string(b) != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.content, b, tc.want)
+		}
+	}
+	if err := trimPartialLine(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+// shortWriter writes up to n bytes to w and then fails, like a disk
+// filling up.
+type shortWriter struct {
+	w io.Writer
+	n int
+}
+
+func (sw *shortWriter) Write(b []byte) (int, error) {
+	if len(b) > sw.n {
+		n, _ := sw.w.Write(b[:sw.n])
+		sw.n = 0
+		return n, errors.New("no space left on device")
+	}
+	sw.n -= len(b)
+	return sw.w.Write(b)
+}
+
+func TestJSONLWriteFails(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st, err := OpenJSONL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.WritePositions(ctx, []model.Position{position(1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, jsonlPositions)
+	before, _ := os.ReadFile(name)
+
+	st.w = bufio.NewWriter(&shortWriter{w: st.file, n: 50})
+	res, err := st.WritePositions(ctx, []model.Position{position(1, 1), position(2, 1)})
+	if err == nil || len(res.Failed) != 2 {
+		t.Fatalf("%d failed, %v, want both positions failed", len(res.Failed), err)
+	}
+	if after, _ := os.ReadFile(name); !bytes.Equal(after, before) {
+		t.Errorf("file after the failed write:\n%s\nwant\n%s", after, before)
+	}
+
+	// The store recovers without a restart.
+	if res, err := st.WritePositions(ctx, []model.Position{position(1, 1), position(2, 1)}); err != nil || res.Written != 2 {
+		t.Fatalf("write after the failure: %d written, %d failed, %v", res.Written, len(res.Failed), err)
+	}
+	st.Close(ctx)
+	if st, err = OpenJSONL(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close(ctx)
+	if ps := allPositions(t, st); len(ps) != 3 {
+		t.Errorf("%d positions, want 3", len(ps))
+	}
+}
@@ -0,0 +1,425 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"TMTU/model"
+)
+
+// Layouts of the MongoDB store.
+const (
+	LayoutPerVehicle = "per-vehicle" // one collection named after each VehId
+	LayoutSingle     = "single"      // all positions in one collection
+	LayoutTimeseries = "timeseries"  // like LayoutSingle, as a MongoDB time-series collection
+)
+
+// writeAttempts is how often a batch is sent before giving up for now.
+const writeAttempts = 3
+
+// MongoOptions configures OpenMongo.
+type MongoOptions struct {
+	URI      string
+	Database string
+	Layout   string
+
+	Collection       string // positions of LayoutSingle and LayoutTimeseries
+	LatestCollection string // latest position per vehicle, keyed by VehId
+
+	// Settings of the time-series collection, applied when it is created.
+	Granularity string        // "seconds", "minutes" or "hours"
+	ExpireAfter time.Duration // 0 keeps positions forever
+
+	// Logf, if set, is told about collections that could not get all
+	// their indexes but are used anyway.
+	Logf func(format string, args ...interface{})
+}
+
+// Mongo stores positions in MongoDB.
+type Mongo struct {
+	client *mongo.Client
+	db     *mongo.Database
+	opts   MongoOptions
+
+	ensured map[string]bool // per-vehicle collections known to be indexed
+}
+
+// OpenMongo connects to MongoDB and creates the collections and indexes of
+// opts.Layout.
+func OpenMongo(ctx context.Context, opts MongoOptions) (*Mongo, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(opts.URI))
+	if err != nil {
+		return nil, err
+	}
+	s := &Mongo{
+		client:  client,
+		db:      client.Database(opts.Database),
+		opts:    opts,
+		ensured: make(map[string]bool),
+	}
+	if err := s.setup(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return s, nil
+}
+
+// setup creates the collections and indexes of the layout. In the
+// per-vehicle layout the existing collections are indexed here and new ones
+// before they are first written to.
+func (s *Mongo) setup(ctx context.Context) error {
+	switch s.opts.Layout {
+	case LayoutSingle:
+		if err := EnsureSingleIndexes(ctx, s.db.Collection(s.opts.Collection)); err != nil {
+			return err
+		}
+	case LayoutTimeseries:
+		if err := s.ensureTimeseriesCollection(ctx); err != nil {
+			return err
+		}
+	case LayoutPerVehicle:
+		names, err := s.db.ListCollectionNames(ctx, bson.D{})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !IsVehicleCollection(name) {
+				continue
+			}
+			if err := s.ensureVehicleIndexes(ctx, name); err != nil {
+				return fmt.Errorf("collection %s: %w", name, err)
+			}
+			s.ensured[name] = true
+		}
+	default:
+		return fmt.Errorf("unknown layout %q", s.opts.Layout)
+	}
+	return nil
+}
+
+func (s *Mongo) collection(vehID int) string {
+	if s.opts.Layout == LayoutPerVehicle {
+		return strconv.Itoa(vehID)
+	}
+	return s.opts.Collection
+}
+
+// WritePositions sends the share of every collection with one unordered
+// InsertMany, retrying with exponential backoff while the server cannot be
+// reached. In the time-series layout nothing prevents duplicates.
+func (s *Mongo) WritePositions(ctx context.Context, ps []model.Position) (Result, error) {
+	byColl := make(map[string][]model.Position)
+	for _, p := range ps {
+		name := s.collection(p.ID)
+		byColl[name] = append(byColl[name], p)
+	}
+	names := make([]string, 0, len(byColl))
+	for name := range byColl {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		total Result
+		errs  []string
+	)
+	for _, name := range names {
+		coll := s.db.Collection(name)
+		if s.opts.Layout == LayoutPerVehicle && !s.ensured[name] {
+			if err := s.ensureVehicleIndexes(ctx, name); err != nil {
+				total.Failed = append(total.Failed, byColl[name]...)
+				errs = append(errs, fmt.Sprintf("collection %s: %v", name, err))
+				continue
+			}
+			s.ensured[name] = true
+		}
+
+		res, err := s.insertWithRetry(ctx, coll, byColl[name])
+		total.add(res)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("collection %s: %v", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return total, errors.New(strings.Join(errs, "; "))
+	}
+	return total, nil
+}
+
+func (s *Mongo) insertWithRetry(ctx context.Context, coll *mongo.Collection, ps []model.Position) (Result, error) {
+	docs := make([]interface{}, len(ps))
+	for i, p := range ps {
+		docs[i] = s.document(p)
+	}
+
+	var err error
+	backoff := time.Second
+	for attempt := 1; attempt <= writeAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return Result{Failed: ps}, ctx.Err()
+			}
+			backoff *= 2
+		}
+		_, err = coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if err == nil {
+			return Result{Written: len(docs)}, nil
+		}
+		var bwe mongo.BulkWriteException
+		if errors.As(err, &bwe) && bwe.WriteConcernError == nil {
+			// The batch reached the server; only single documents failed.
+			return writeErrorResult(len(docs), bwe), droppedError(bwe)
+		}
+	}
+	return Result{Failed: ps}, fmt.Errorf("%d positions not written: %w", len(docs), err)
+}
+
+// writeErrorResult counts the outcome of an InsertMany of n documents that
+// failed with bwe.
+func writeErrorResult(n int, bwe mongo.BulkWriteException) Result {
+	res := Result{Written: n - len(bwe.WriteErrors)}
+	for _, we := range bwe.WriteErrors {
+		if mongo.IsDuplicateKeyError(we) {
+			res.Duplicates++
+		} else {
+			res.Dropped++
+		}
+	}
+	return res
+}
+
+// droppedError describes the write errors of bwe other than duplicates.
+func droppedError(bwe mongo.BulkWriteException) error {
+	var msgs []string
+	for _, we := range bwe.WriteErrors {
+		if !mongo.IsDuplicateKeyError(we) {
+			msgs = append(msgs, we.Message)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d positions dropped: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// latestPosition is a document of the latest-position collection.
+type latestPosition struct {
+	VehID          int `bson:"_id"`
+	model.Position `bson:",inline"`
+}
+
+// UpsertLatest replaces the document of each vehicle in the latest-position
+// collection unless it holds a newer position.
+func (s *Mongo) UpsertLatest(ctx context.Context, ps []model.Position) error {
+	if len(ps) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, len(ps))
+	for i, p := range ps {
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.D{
+				{Key: "_id", Value: p.ID},
+				{Key: "LastTrackdt", Value: bson.D{{Key: "$lte", Value: p.LastTrackdt}}},
+			}).
+			SetReplacement(latestPosition{VehID: p.ID, Position: p}).
+			SetUpsert(true)
+	}
+	_, err := s.db.Collection(s.opts.LatestCollection).BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError == nil && droppedError(bwe) == nil {
+		return nil // the upsert collided with a newer position
+	}
+	return err
+}
+
+// Positions queries the collection of vehID, or every position collection
+// if vehID is 0.
+func (s *Mongo) Positions(ctx context.Context, vehID int, from, to time.Time) ([]model.Position, error) {
+	filter := bson.D{{Key: "LastTrackdt", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}}}}
+	if vehID != 0 {
+		filter = append(filter, bson.E{Key: "VehId", Value: vehID})
+	}
+
+	names := []string{s.collection(vehID)}
+	if s.opts.Layout == LayoutPerVehicle && vehID == 0 {
+		all, err := s.db.ListCollectionNames(ctx, bson.D{})
+		if err != nil {
+			return nil, err
+		}
+		names = names[:0]
+		for _, name := range all {
+			if IsVehicleCollection(name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var ps []model.Position
+	for _, name := range names {
+		cur, err := s.db.Collection(name).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "LastTrackdt", Value: 1}}))
+		if err != nil {
+			return nil, err
+		}
+		var found []model.Position
+		if err := cur.All(ctx, &found); err != nil {
+			return nil, err
+		}
+		ps = append(ps, found...)
+	}
+	sortPositions(ps)
+	return ps, nil
+}
+
+func (s *Mongo) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// timeseriesPosition is a position as stored in a time-series collection.
+// MongoDB groups measurements by the meta field, so VehId and RouteNo are
+// copied there; the top-level fields stay for queries written for the other
+// layouts.
+type timeseriesPosition struct {
+	Meta           positionMeta `bson:"meta"`
+	model.Position `bson:",inline"`
+}
+
+type positionMeta struct {
+	VehID   int `bson:"VehId"`
+	RouteNo int `bson:"RouteNo"`
+}
+
+// document returns p in the document layout of s.
+func (s *Mongo) document(p model.Position) interface{} {
+	if s.opts.Layout == LayoutTimeseries {
+		return timeseriesPosition{Meta: positionMeta{VehID: p.ID, RouteNo: p.RouteNo}, Position: p}
+	}
+	return p
+}
+
+// Indexes of the position collections. The unique VehId/LastTrackdt index
+// keeps a position from being stored twice, e.g. when the tracker restarts
+// and sees the last positions again; the resulting duplicate key errors are
+// expected. Time-series collections cannot have unique indexes.
+var (
+	locationIndex = mongo.IndexModel{Keys: bson.D{{Key: "location", Value: "2dsphere"}}}
+	uniqueIndex   = mongo.IndexModel{
+		Keys:    bson.D{{Key: "VehId", Value: 1}, {Key: "LastTrackdt", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	vehicleIndexes = []mongo.IndexModel{uniqueIndex, locationIndex}
+	singleIndexes  = []mongo.IndexModel{
+		uniqueIndex,
+		{Keys: bson.D{{Key: "RouteNo", Value: 1}, {Key: "LastTrackdt", Value: 1}}},
+		{Keys: bson.D{{Key: "LastTrackdt", Value: 1}}},
+		locationIndex,
+	}
+	timeseriesIndexes = []mongo.IndexModel{
+		{Keys: bson.D{{Key: "meta.VehId", Value: 1}, {Key: "LastTrackdt", Value: 1}}},
+		{Keys: bson.D{{Key: "meta.RouteNo", Value: 1}, {Key: "LastTrackdt", Value: 1}}},
+		locationIndex,
+	}
+)
+
+// EnsureSingleIndexes creates the indexes of a collection holding the
+// positions of many vehicles.
+func EnsureSingleIndexes(ctx context.Context, coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateMany(ctx, singleIndexes)
+	return err
+}
+
+// ensureVehicleIndexes creates the indexes of the per-vehicle collection
+// name. Older versions stored every LastTrackdt they could not parse as
+// 0001-01-01, so a collection may hold a VehId/LastTrackdt pair twice; it is
+// then used without the unique index, as before, and Logf is told.
+func (s *Mongo) ensureVehicleIndexes(ctx context.Context, name string) error {
+	coll := s.db.Collection(name)
+	_, err := coll.Indexes().CreateMany(ctx, vehicleIndexes)
+	if err == nil || !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	if _, err := coll.Indexes().CreateOne(ctx, locationIndex); err != nil {
+		return err
+	}
+	if s.opts.Logf != nil {
+		s.opts.Logf("collection %s has duplicate positions, used without the unique VehId/LastTrackdt index: %v", name, err)
+	}
+	return nil
+}
+
+// IsVehicleCollection reports whether name is a collection of
+// LayoutPerVehicle.
+func IsVehicleCollection(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureTimeseriesCollection creates the time-series collection on
+// LastTrackdt and its indexes. If it exists already only its expiry is
+// updated, MongoDB does not allow changing the rest.
+func (s *Mongo) ensureTimeseriesCollection(ctx context.Context) error {
+	name := s.opts.Collection
+	specs, err := s.db.ListCollectionSpecifications(ctx, bson.D{{Key: "name", Value: name}})
+	if err != nil {
+		return err
+	}
+	expire := int64(s.opts.ExpireAfter / time.Second)
+
+	if len(specs) > 0 {
+		if specs[0].Type != "timeseries" {
+			return fmt.Errorf("collection %s exists and is not a time-series collection", name)
+		}
+		var expireAfter interface{} = "off"
+		if expire > 0 {
+			expireAfter = expire
+		}
+		err := s.db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: name},
+			{Key: "expireAfterSeconds", Value: expireAfter},
+		}).Err()
+		if err != nil {
+			return err
+		}
+	} else {
+		opts := options.CreateCollection().SetTimeSeriesOptions(options.TimeSeries().
+			SetTimeField("LastTrackdt").
+			SetMetaField("meta").
+			SetGranularity(s.opts.Granularity))
+		if expire > 0 {
+			opts.SetExpireAfterSeconds(expire)
+		}
+		if err := s.db.CreateCollection(ctx, name, opts); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.db.Collection(name).Indexes().CreateMany(ctx, timeseriesIndexes)
+	return err
+}
+
+func sortPositions(ps []model.Position) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		if !ps[i].LastTrackdt.Equal(ps[j].LastTrackdt) {
+			return ps[i].LastTrackdt.Before(ps[j].LastTrackdt)
+		}
+		return ps[i].ID < ps[j].ID
+	})
+}
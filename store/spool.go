@@ -0,0 +1,271 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"TMTU/model"
+)
+
+// Spool is a write-ahead queue of positions waiting for a Store. Each Push
+// becomes a batch file in the spool directory, written and synced before
+// Push returns, so queued positions survive a crash or restart. Drain hands
+// the batches to a store oldest first and removes them once stored.
+//
+// A Spool with an empty directory keeps the batches in memory only.
+//
+// Batch files that cannot be read are renamed to <name>.bad and left for
+// inspection, so one damaged file does not hold up the rest of the queue.
+type Spool struct {
+	dir         string
+	batches     []*spoolBatch
+	next        int // sequence number of the next batch file
+	peak        int
+	quarantined []string
+}
+
+type spoolBatch struct {
+	name   string           // file name, empty in memory
+	ps     []model.Position // nil until loaded, for files
+	n      int
+	queued time.Time
+}
+
+// SpoolStats describes the queue of a Spool.
+type SpoolStats struct {
+	Positions int       `json:"positions"` // queued positions
+	Batches   int       `json:"batches"`   // queued batches
+	Oldest    time.Time `json:"oldest,omitempty"`
+	Peak      int       `json:"peak"` // most positions queued at once since OpenSpool
+}
+
+// OpenSpool opens the spool in dir, creating dir if needed, and queues the
+// batches left there by an earlier run. Batches that cannot be read are
+// quarantined, see Quarantined.
+func OpenSpool(dir string) (*Spool, error) {
+	s := &Spool{dir: dir}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries { // sorted by name, which is the batch order
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(dir, name)) // a Push that did not finish
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(name, ".jsonl"))
+		if err != nil || !strings.HasSuffix(name, ".jsonl") {
+			continue
+		}
+		s.next = seq + 1
+		ps, err := readSpoolFile(filepath.Join(dir, name))
+		if err != nil {
+			if qerr := s.quarantine(name, err); qerr != nil {
+				return nil, qerr
+			}
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.batches = append(s.batches, &spoolBatch{name: name, n: len(ps), queued: info.ModTime()})
+	}
+	s.peak = s.Stats().Positions
+	return s, nil
+}
+
+// Push queues ps as one batch. If the batch file cannot be written, the
+// batch is still queued, in memory, and the error says so.
+func (s *Spool) Push(ps []model.Position) error {
+	if len(ps) == 0 {
+		return nil
+	}
+	b := &spoolBatch{n: len(ps), queued: time.Now()}
+	var err error
+	if s.dir == "" {
+		b.ps = ps
+	} else {
+		name := fmt.Sprintf("%012d.jsonl", s.next)
+		if err = writeSpoolFile(filepath.Join(s.dir, name), ps); err != nil {
+			b.ps = ps
+			err = fmt.Errorf("batch kept in memory: %w", err)
+		} else {
+			b.name = name
+			s.next++
+		}
+	}
+	s.batches = append(s.batches, b)
+	if n := s.Stats().Positions; n > s.peak {
+		s.peak = n
+	}
+	return err
+}
+
+// Drain writes up to max queued batches (all if max <= 0) to st, oldest
+// first, and records each as the latest positions. It stops at the first
+// batch st cannot take completely; what is left of that batch stays at the
+// head of the queue. Positions st refuses are counted in Result.Dropped
+// and described by the error, but do not stop the drain, and neither does a
+// failure to record the latest positions: the next positions of the same
+// vehicles repair it.
+func (s *Spool) Drain(ctx context.Context, st Store, max int) (Result, error) {
+	var (
+		total Result
+		errs  []string
+	)
+	done := func(err error) (Result, error) {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		if len(errs) > 0 {
+			return total, errors.New(strings.Join(errs, "; "))
+		}
+		return total, nil
+	}
+	for n := 0; len(s.batches) > 0 && (max <= 0 || n < max); n++ {
+		b := s.batches[0]
+		ps := b.ps
+		if ps == nil {
+			var err error
+			if ps, err = readSpoolFile(filepath.Join(s.dir, b.name)); err != nil {
+				s.batches = s.batches[1:]
+				if qerr := s.quarantine(b.name, err); qerr != nil {
+					return done(qerr)
+				}
+				return done(fmt.Errorf("%w, %d positions moved to %s.bad", err, b.n, b.name))
+			}
+		}
+		res, err := st.WritePositions(ctx, ps)
+		total.Written += res.Written
+		total.Duplicates += res.Duplicates
+		total.Dropped += res.Dropped
+		if len(res.Failed) > 0 {
+			if rerr := s.replace(b, res.Failed); rerr != nil {
+				return done(rerr)
+			}
+			if err == nil {
+				err = fmt.Errorf("%d positions not written", len(res.Failed))
+			}
+			return done(err)
+		}
+		if err != nil {
+			errs = append(errs, err.Error()) // positions st refused, the batch is done
+		}
+		if err := st.UpsertLatest(ctx, ps); err != nil {
+			errs = append(errs, fmt.Sprintf("latest positions: %v", err))
+		}
+		if b.name != "" {
+			if err := os.Remove(filepath.Join(s.dir, b.name)); err != nil {
+				return done(err)
+			}
+		}
+		s.batches = s.batches[1:]
+	}
+	return done(nil)
+}
+
+// quarantine renames the batch file name, which could not be read because
+// of readErr, to name.bad.
+func (s *Spool) quarantine(name string, readErr error) error {
+	path := filepath.Join(s.dir, name)
+	if err := os.Rename(path, path+".bad"); err != nil {
+		return fmt.Errorf("%v; moving it aside: %w", readErr, err)
+	}
+	s.quarantined = append(s.quarantined, name)
+	return nil
+}
+
+// Quarantined returns the batch files that could not be read, oldest
+// first. Each was renamed to <name>.bad in the spool directory.
+func (s *Spool) Quarantined() []string {
+	return s.quarantined
+}
+
+// replace keeps only ps of batch b.
+func (s *Spool) replace(b *spoolBatch, ps []model.Position) error {
+	if b.name != "" {
+		if err := writeSpoolFile(filepath.Join(s.dir, b.name), ps); err != nil {
+			return err
+		}
+	} else {
+		b.ps = ps
+	}
+	b.n = len(ps)
+	return nil
+}
+
+// Stats returns the current queue depth.
+func (s *Spool) Stats() SpoolStats {
+	st := SpoolStats{Batches: len(s.batches), Peak: s.peak}
+	for _, b := range s.batches {
+		st.Positions += b.n
+	}
+	if len(s.batches) > 0 {
+		st.Oldest = s.batches[0].queued
+	}
+	return st
+}
+
+// writeSpoolFile replaces name with ps as JSON lines, syncing the file
+// before it takes the place of the old one.
+func writeSpoolFile(name string, ps []model.Position) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, p := range ps {
+		if err := enc.Encode(p); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func readSpoolFile(name string) ([]model.Position, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ps []model.Position
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var p model.Position
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
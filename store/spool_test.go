@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"TMTU/model"
+)
+
+func spoolFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestSpoolRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	sp, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Push([]model.Position{position(1, 0), position(2, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Push([]model.Position{position(1, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Push(nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"000000000000.jsonl", "000000000001.jsonl"}
+	if got := spoolFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("spool files %v, want %v", got, want)
+	}
+
+	// The queue survives a restart.
+	if sp, err = OpenSpool(dir); err != nil {
+		t.Fatal(err)
+	}
+	if st := sp.Stats(); st.Positions != 3 || st.Batches != 2 || st.Peak != 3 || st.Oldest.IsZero() {
+		t.Errorf("stats after reopening %+v", st)
+	}
+
+	st, err := OpenJSONL(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close(ctx)
+	res, err := sp.Drain(ctx, st, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Written != 2 || sp.Stats().Positions != 1 {
+		t.Errorf("drain of one batch: %+v, %d left", res, sp.Stats().Positions)
+	}
+	if res, err = sp.Drain(ctx, st, 0); err != nil || res.Written != 1 {
+		t.Errorf("drain of the rest: %+v, %v", res, err)
+	}
+	if files := spoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("files left after draining: %v", files)
+	}
+	if ps := allPositions(t, st); len(ps) != 3 {
+		t.Errorf("%d positions stored, want 3", len(ps))
+	}
+	if len(st.latest) != 2 {
+		t.Errorf("latest positions of %d vehicles, want 2", len(st.latest))
+	}
+
+	// Numbering continues after the batches already used.
+	if err := sp.Push([]model.Position{position(3, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if files := spoolFiles(t, dir); !reflect.DeepEqual(files, []string{"000000000002.jsonl"}) {
+		t.Errorf("spool files %v", files)
+	}
+}
+
+func TestSpoolMemory(t *testing.T) {
+	sp, err := OpenSpool("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Push([]model.Position{position(1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	st, err := OpenJSONL(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close(context.Background())
+	if res, err := sp.Drain(context.Background(), st, 0); err != nil || res.Written != 1 {
+		t.Errorf("drain: %+v, %v", res, err)
+	}
+}
+
+// TestSpoolWriteFails checks that a batch file that could not be written
+// completely is neither left in place nor reported as written.
+func TestSpoolWriteFails(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("needs /dev/full")
+	}
+	dir := t.TempDir()
+	sp, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The batch file is written through name.tmp, which here leads to a
+	// full disk.
+	if err := os.Symlink("/dev/full", filepath.Join(dir, "000000000000.jsonl.tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Push([]model.Position{position(1, 0)}); err == nil {
+		t.Fatal("Push to a full disk succeeded")
+	}
+	if files := spoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("files left after a failed write: %v", files)
+	}
+	if st := sp.Stats(); st.Positions != 1 {
+		t.Errorf("%d positions queued, want the batch kept in memory", st.Positions)
+	}
+}
+
+func TestSpoolQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := sp.Push([]model.Position{position(1, i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad := "000000000001.jsonl"
+	if err := os.WriteFile(filepath.Join(dir, bad), []byte(`{"VehId":1,"LastTr`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if sp, err = OpenSpool(dir); err != nil {
+		t.Fatalf("opening with a damaged batch: %v", err)
+	}
+	if got := sp.Quarantined(); !reflect.DeepEqual(got, []string{bad}) {
+		t.Errorf("Quarantined() = %v", got)
+	}
+	if st := sp.Stats(); st.Positions != 2 {
+		t.Errorf("%d positions queued, want 2", st.Positions)
+	}
+	if _, err := os.Stat(filepath.Join(dir, bad+".bad")); err != nil {
+		t.Error(err)
+	}
+	if err := sp.Push([]model.Position{position(1, 9)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "000000000003.jsonl")); err != nil {
+		t.Errorf("numbering does not continue after the damaged batch: %v", err)
+	}
+
+	// A batch damaged while queued is moved aside by Drain.
+	if err := os.WriteFile(filepath.Join(dir, "000000000000.jsonl"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := OpenJSONL(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close(context.Background())
+	if _, err := sp.Drain(context.Background(), st, 0); err == nil {
+		t.Error("no error for a damaged batch")
+	}
+	if res, err := sp.Drain(context.Background(), st, 0); err != nil || res.Written != 2 {
+		t.Errorf("drain after the damaged batch: %+v, %v", res, err)
+	}
+	if len(sp.Quarantined()) != 2 {
+		t.Errorf("Quarantined() = %v", sp.Quarantined())
+	}
+}
+
+// refusingStore refuses the first position of every write, like MongoDB
+// refusing a document, and cannot record latest positions.
+type refusingStore struct {
+	Store
+}
+
+func (refusingStore) WritePositions(_ context.Context, ps []model.Position) (Result, error) {
+	return Result{Written: len(ps) - 1, Dropped: 1}, errors.New("1 positions dropped: invalid coordinates")
+}
+
+func (refusingStore) UpsertLatest(context.Context, []model.Position) error {
+	return errors.New("latest collection unavailable")
+}
+
+func TestSpoolDrainDropped(t *testing.T) {
+	sp, err := OpenSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sp.Push([]model.Position{position(1, i), position(2, i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := sp.Drain(context.Background(), refusingStore{}, 0)
+	if res.Written != 2 || res.Dropped != 2 {
+		t.Errorf("result %+v, want 2 written and 2 dropped", res)
+	}
+	if err == nil || !strings.Contains(err.Error(), "invalid coordinates") || !strings.Contains(err.Error(), "latest collection unavailable") {
+		t.Errorf("error %v does not tell about the dropped positions and the latest positions", err)
+	}
+	if st := sp.Stats(); st.Positions != 0 {
+		t.Errorf("%d positions still queued, refused positions must not be retried", st.Positions)
+	}
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go driver, no cgo needed
+
+	"TMTU/model"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS positions (
+	veh_id       INTEGER NOT NULL,
+	last_trackdt INTEGER NOT NULL, -- Unix milliseconds
+	route_no     INTEGER NOT NULL,
+	longitude    REAL NOT NULL,
+	latitude     REAL NOT NULL,
+	doc          TEXT NOT NULL,    -- the model.Position as JSON
+	PRIMARY KEY (veh_id, last_trackdt)
+);
+CREATE INDEX IF NOT EXISTS positions_last_trackdt ON positions (last_trackdt);
+CREATE INDEX IF NOT EXISTS positions_route_no ON positions (route_no, last_trackdt);
+CREATE TABLE IF NOT EXISTS latest (
+	veh_id       INTEGER PRIMARY KEY,
+	last_trackdt INTEGER NOT NULL,
+	doc          TEXT NOT NULL
+);
+`
+
+// SQLite stores positions in an SQLite database file.
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens or creates the database at path.
+func OpenSQLite(ctx context.Context, path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // SQLite allows a single writer
+	for _, pragma := range []string{"PRAGMA journal_mode = WAL", "PRAGMA busy_timeout = 5000"} {
+		if _, err := db.ExecContext(ctx, pragma); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLite{db: db}, nil
+}
+
+// WritePositions inserts ps in one transaction.
+func (s *SQLite) WritePositions(ctx context.Context, ps []model.Position) (Result, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{Failed: ps}, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO positions
+		(veh_id, last_trackdt, route_no, longitude, latitude, doc) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return Result{Failed: ps}, err
+	}
+	defer stmt.Close()
+
+	var res Result
+	for _, p := range ps {
+		doc, err := json.Marshal(p)
+		if err != nil {
+			return Result{Failed: ps}, err
+		}
+		r, err := stmt.ExecContext(ctx, p.ID, p.LastTrackdt.UnixMilli(), p.RouteNo,
+			p.Location.Coordinates[0], p.Location.Coordinates[1], string(doc))
+		if err != nil {
+			return Result{Failed: ps}, err
+		}
+		if n, _ := r.RowsAffected(); n == 0 {
+			res.Duplicates++
+		} else {
+			res.Written++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Result{Failed: ps}, err
+	}
+	return res, nil
+}
+
+// UpsertLatest replaces the row of each vehicle unless it holds a newer
+// position.
+func (s *SQLite) UpsertLatest(ctx context.Context, ps []model.Position) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range ps {
+		doc, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO latest (veh_id, last_trackdt, doc) VALUES (?, ?, ?)
+			ON CONFLICT (veh_id) DO UPDATE SET last_trackdt = excluded.last_trackdt, doc = excluded.doc
+			WHERE excluded.last_trackdt >= latest.last_trackdt`,
+			p.ID, p.LastTrackdt.UnixMilli(), string(doc))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) Positions(ctx context.Context, vehID int, from, to time.Time) ([]model.Position, error) {
+	query := `SELECT doc FROM positions WHERE last_trackdt >= ? AND last_trackdt < ?`
+	args := []interface{}{from.UnixMilli(), to.UnixMilli()}
+	if vehID != 0 {
+		query += ` AND veh_id = ?`
+		args = append(args, vehID)
+	}
+	query += ` ORDER BY last_trackdt, veh_id`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ps []model.Position
+	for rows.Next() {
+		var doc string
+		if err := rows.Scan(&doc); err != nil {
+			return nil, err
+		}
+		var p model.Position
+		if err := json.Unmarshal([]byte(doc), &p); err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, rows.Err()
+}
+
+func (s *SQLite) Close(context.Context) error {
+	return s.db.Close()
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"TMTU/model"
+)
+
+// latestMinute returns the minute of the latest position of vehicle id.
+func latestMinute(t *testing.T, s *SQLite, id int) int {
+	t.Helper()
+	var ms int64
+	if err := s.db.QueryRow(`SELECT last_trackdt FROM latest WHERE veh_id = ?`, id).Scan(&ms); err != nil {
+		t.Fatal(err)
+	}
+	return time.UnixMilli(ms).UTC().Minute()
+}
+
+func TestSQLite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "positions.db")
+	st, err := OpenSQLite(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := st.WritePositions(ctx, []model.Position{position(1, 0), position(2, 0), position(1, 0), position(1, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Written != 3 || res.Duplicates != 1 {
+		t.Errorf("result %+v, want 3 written and 1 duplicate", res)
+	}
+	if err := st.UpsertLatest(ctx, []model.Position{position(1, 5), position(1, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertLatest(ctx, []model.Position{position(1, 4), position(2, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := latestMinute(t, st, 1); got != 5 {
+		t.Errorf("latest position of vehicle 1 at minute %d, want 5", got)
+	}
+	if got := latestMinute(t, st, 2); got != 1 {
+		t.Errorf("latest position of vehicle 2 at minute %d, want 1", got)
+	}
+	if err := st.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened, the database still rejects what it holds.
+	if st, err = OpenSQLite(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close(ctx)
+	if res, _ := st.WritePositions(ctx, []model.Position{position(2, 0)}); res.Duplicates != 1 {
+		t.Errorf("position stored before reopening: result %+v, want a duplicate", res)
+	}
+
+	at := func(minute int) time.Time { return position(0, minute).LastTrackdt }
+	for _, tc := range []struct {
+		name     string
+		vehID    int
+		from, to time.Time
+		want     []positionKey
+	}{
+		{"all", 0, at(0), at(60), []positionKey{keyOf(position(1, 0)), keyOf(position(2, 0)), keyOf(position(1, 2))}},
+		{"one vehicle", 1, at(0), at(60), []positionKey{keyOf(position(1, 0)), keyOf(position(1, 2))}},
+		{"to is excluded", 0, at(0), at(2), []positionKey{keyOf(position(1, 0)), keyOf(position(2, 0))}},
+		{"from is included", 1, at(2), at(3), []positionKey{keyOf(position(1, 2))}},
+		{"empty", 2, at(1), at(60), nil},
+	} {
+		ps, err := st.Positions(ctx, tc.vehID, tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []positionKey
+		for _, p := range ps {
+			got = append(got, keyOf(p))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	ps, err := st.Positions(ctx, 1, at(2), at(3))
+	if err != nil || len(ps) != 1 {
+		t.Fatalf("%d positions, %v", len(ps), err)
+	}
+	if p := ps[0]; p.ID != 1 || !p.LastTrackdt.Equal(at(2)) || p.Location.Coordinates[0] != 72.97 {
+		t.Errorf("position read back as %+v", p)
+	}
+}
@@ -0,0 +1,53 @@
+// Package store keeps tracked bus positions. Store has implementations for
+// MongoDB, an SQLite file and JSON Lines files.
+package store
+
+import (
+	"context"
+	"time"
+
+	"TMTU/model"
+)
+
+// Store is where the tracker puts positions.
+type Store interface {
+	// WritePositions stores ps. A position that is already stored (same
+	// vehicle and LastTrackdt) is counted as a duplicate, not an error.
+	// Positions that could not be sent are returned in Result.Failed so the
+	// caller can offer them again.
+	WritePositions(ctx context.Context, ps []model.Position) (Result, error)
+
+	// UpsertLatest records each of ps as the latest position of its vehicle.
+	UpsertLatest(ctx context.Context, ps []model.Position) error
+
+	// Positions returns the positions of vehicle vehID (all vehicles if 0)
+	// with from <= LastTrackdt < to, oldest first.
+	Positions(ctx context.Context, vehID int, from, to time.Time) ([]model.Position, error)
+
+	Close(ctx context.Context) error
+}
+
+// Result tells what happened to the positions of a WritePositions call.
+type Result struct {
+	Written    int              // newly stored
+	Duplicates int              // already stored, e.g. before a restart
+	Dropped    int              // refused by the backend, e.g. for invalid coordinates
+	Failed     []model.Position // not sent, worth trying again
+}
+
+func (r *Result) add(o Result) {
+	r.Written += o.Written
+	r.Duplicates += o.Duplicates
+	r.Dropped += o.Dropped
+	r.Failed = append(r.Failed, o.Failed...)
+}
+
+// positionKey identifies a position across all backends.
+type positionKey struct {
+	vehID int
+	t     int64 // LastTrackdt in Unix milliseconds, the precision of BSON dates
+}
+
+func keyOf(p model.Position) positionKey {
+	return positionKey{vehID: p.ID, t: p.LastTrackdt.UnixMilli()}
+}
@@ -0,0 +1,217 @@
+// Package tmt is a client for the Thane Municipal Transport tracking API
+// (https://tmtitsapi.locationtracker.com/api/) used by the WHEREISMYTMTBUS app.
+package tmt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBaseURL is the production endpoint of the TMT API.
+const DefaultBaseURL = "http://tmtitsapi.locationtracker.com/api"
+
+// Client talks to the TMT API. The zero value is not usable, use NewClient.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+
+	// Limiter, if set, paces the requests; after a 429 Too Many Requests
+	// it also decides when the request is sent again.
+	Limiter *RateLimiter
+
+	// Retry says how often and how soon a request that failed with a
+	// Temporary error is sent again.
+	Retry RetryPolicy
+
+	// Logf, if set, is told about every retry.
+	Logf func(format string, args ...interface{})
+}
+
+// RetryPolicy is a jittered exponential backoff: before attempt n+1 it
+// waits a random time between zero and Base*2^(n-1), at most Max.
+type RetryPolicy struct {
+	Attempts int // sends of one request in total, 1 disables retries
+	Base     time.Duration
+	Max      time.Duration
+}
+
+// DefaultRetry is the RetryPolicy of NewClient.
+var DefaultRetry = RetryPolicy{Attempts: 4, Base: time.Second, Max: 30 * time.Second}
+
+// backoff returns the wait before the attempt after attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Base << (attempt - 1)
+	if d > p.Max || d <= 0 {
+		d = p.Max
+	}
+	jitter.Lock()
+	defer jitter.Unlock()
+	return time.Duration(jitter.Int63n(int64(d) + 1))
+}
+
+// jitter is seeded so clients started together do not retry in step.
+var jitter = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// NewClient returns a Client for baseURL. An empty baseURL selects
+// DefaultBaseURL and a nil httpClient selects http.DefaultClient.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: httpClient,
+		Retry:      DefaultRetry,
+	}
+}
+
+// GetWaypoints returns every bus stop known to the API.
+func (c *Client) GetWaypoints(ctx context.Context) (*ResponseWaypoints, error) {
+	var result ResponseWaypoints
+	if err := c.get(ctx, "getWayPoints", &result, &result.Meta); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 {
+		return nil, &EmptyError{Endpoint: "getWayPoints"}
+	}
+	return &result, nil
+}
+
+// GetRouteMaster returns the list of all routes.
+func (c *Client) GetRouteMaster(ctx context.Context) (*ResponseRouteMaster, error) {
+	var result ResponseRouteMaster
+	if err := c.get(ctx, "getRouteMaster", &result, &result.Meta); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 {
+		return nil, &EmptyError{Endpoint: "getRouteMaster"}
+	}
+	return &result, nil
+}
+
+// GetRouteDetails returns the stops of the route identified by routeNo
+// (the RouteNo field of a RouteMaster entry).
+func (c *Client) GetRouteDetails(ctx context.Context, routeNo string) (*ResponseRouteNo, error) {
+	var result ResponseRouteNo
+	form := url.Values{"RouteNo": {routeNo}}
+	if err := c.postForm(ctx, "getRouteDetailsNew", form, &result, &result.Meta); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 {
+		return nil, &EmptyError{Endpoint: "getRouteDetailsNew"}
+	}
+	return &result, nil
+}
+
+// GetLastTrackingData returns the latest known position of every bus.
+func (c *Client) GetLastTrackingData(ctx context.Context) (*ResponseBusLocations, error) {
+	var result ResponseBusLocations
+	if err := c.get(ctx, "getLastTrackingData", &result, &result.Meta); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 {
+		return nil, &EmptyError{Endpoint: "getLastTrackingData"}
+	}
+	return &result, nil
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, v interface{}, meta *Meta) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/"+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(endpoint, req, v, meta)
+}
+
+func (c *Client) postForm(ctx context.Context, endpoint string, form url.Values, v interface{}, meta *Meta) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/"+endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(endpoint, req, v, meta)
+}
+
+// do sends req, decodes the JSON body into v and fills meta. Requests that
+// fail with a Temporary error are sent again as c.Retry allows.
+func (c *Client) do(endpoint string, req *http.Request, v interface{}, meta *Meta) error {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			b, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			req.Body = b
+		}
+		err := c.send(endpoint, req, v, meta)
+		if err == nil || !Temporary(err) || attempt >= c.Retry.Attempts || ctx.Err() != nil {
+			return err
+		}
+
+		var se *StatusError
+		if errors.As(err, &se) && se.StatusCode == http.StatusTooManyRequests && c.Limiter != nil {
+			continue // Limiter.Wait holds the next attempt back as asked
+		}
+		wait := c.Retry.backoff(attempt)
+		if c.Logf != nil {
+			c.Logf("%v; retrying in %s (attempt %d of %d)", err, wait.Round(time.Millisecond), attempt+1, c.Retry.Attempts)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+// send makes one attempt at req.
+func (c *Client) send(endpoint string, req *http.Request, v interface{}, meta *Meta) error {
+	if c.Limiter != nil {
+		if err := c.Limiter.Wait(req.Context()); err != nil {
+			return err
+		}
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return &TransportError{Method: req.Method, Endpoint: endpoint, Err: err}
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return &TransportError{Method: req.Method, Endpoint: endpoint, Err: err}
+	}
+	if c.Limiter != nil {
+		c.Limiter.Update(resp)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Method: req.Method, Endpoint: endpoint, StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+	}
+	// The status comes first: a failed call may not fit the type of v,
+	// e.g. when "messages" is a list of validation errors.
+	if err := checkStatus(endpoint, body); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return &DecodeError{Endpoint: endpoint, Body: body, Err: err}
+	}
+	meta.Header = resp.Header
+	meta.Raw = body
+	return nil
+}
@@ -0,0 +1,188 @@
+package tmt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client for handler that retries at once.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	c := NewClient(ts.URL+"/api/", nil)
+	c.Retry = RetryPolicy{Attempts: 3, Base: time.Millisecond, Max: time.Millisecond}
+	return c
+}
+
+const routeMaster = `{"status":"success","messages":"Route Master","data":[{"RouteNo":"1","RouteNum":"1"}]}`
+
+func TestClientRetriesTemporary(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, routeMaster)
+	})
+	var retries int
+	c.Logf = func(string, ...interface{}) { retries++ }
+
+	master, err := c.GetRouteMaster(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(master.Data) != 1 || master.Data[0].RouteNo != "1" || len(master.Raw) == 0 {
+		t.Errorf("response %+v", master)
+	}
+	if atomic.LoadInt32(&calls) != 3 || retries != 2 {
+		t.Errorf("%d calls and %d retries logged, want 3 and 2", atomic.LoadInt32(&calls), retries)
+	}
+}
+
+func TestClientResendsForm(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("RouteNo") != "3" {
+			http.Error(w, "invalid RouteNo", http.StatusBadRequest)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "busy", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":[{"RouteNo":3,"route_details":[]}]}`)
+	})
+	route, err := c.GetRouteDetails(context.Background(), "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.Data[0].RouteNo != 3 || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("RouteNo %d after %d calls", route.Data[0].RouteNo, atomic.LoadInt32(&calls))
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		status    int
+		body      string
+		wantCalls int32
+		check     func(error) bool
+	}{
+		{"bad request", http.StatusBadRequest, "no", 1, func(err error) bool {
+			var e *StatusError
+			return errors.As(err, &e) && e.StatusCode == http.StatusBadRequest && string(e.Body) == "no"
+		}},
+		{"server error", http.StatusInternalServerError, "", 3, func(err error) bool {
+			var e *StatusError
+			return errors.As(err, &e) && e.StatusCode == http.StatusInternalServerError
+		}},
+		{"API status", http.StatusOK, `{"status":"error","messages":"Invalid token","data":[]}`, 1, func(err error) bool {
+			var e *APIError
+			return errors.As(err, &e) && e.Status == "error" && e.Messages == "Invalid token" && e.Endpoint == "getRouteMaster"
+		}},
+		{"API messages list", http.StatusOK, `{"status":"fail","messages":["a","b"]}`, 1, func(err error) bool {
+			var e *APIError
+			return errors.As(err, &e) && e.Messages == `["a","b"]`
+		}},
+		{"cut off", http.StatusOK, `{"status":"success","data":[{"Rou`, 3, func(err error) bool {
+			var e *DecodeError
+			return errors.As(err, &e) && e.Endpoint == "getRouteMaster"
+		}},
+		{"no data", http.StatusOK, `{"status":"success","messages":"","data":[]}`, 1, func(err error) bool {
+			var e *EmptyError
+			return errors.As(err, &e)
+		}},
+	} {
+		var calls int32
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(tc.status)
+			fmt.Fprint(w, tc.body)
+		})
+		_, err := c.GetRouteMaster(context.Background())
+		if !tc.check(err) {
+			t.Errorf("%s: wrong error %#v", tc.name, err)
+		}
+		if atomic.LoadInt32(&calls) != tc.wantCalls {
+			t.Errorf("%s: %d calls, want %d", tc.name, atomic.LoadInt32(&calls), tc.wantCalls)
+		}
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select { // a stalled connection
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	defer close(release)
+	c.HTTPClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	_, err := c.GetRouteMaster(context.Background())
+	var e *TransportError
+	if !errors.As(err, &e) || !Temporary(err) {
+		t.Fatalf("got %#v, want a temporary TransportError", err)
+	}
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("%d calls, want 3", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestClientRateLimited(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "Too Many Attempts.", http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, routeMaster)
+	})
+	c.Limiter = NewRateLimiter(0)
+	if _, err := c.GetRouteMaster(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("%d calls, want 2", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestClientCancelled(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	})
+	c.Retry = RetryPolicy{Attempts: 10, Base: time.Hour, Max: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetRouteMaster(ctx); err == nil {
+		t.Fatal("no error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("cancelled request returned after %s", d)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Base: time.Second, Max: 5 * time.Second}
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 60: 5 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(attempt); d < 0 || d > max {
+				t.Fatalf("backoff(%d) = %s, want at most %s", attempt, d, max)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package tmt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// TransportError is returned when a request got no response, e.g. because
+// the connection failed or timed out.
+type TransportError struct {
+	Method   string
+	Endpoint string
+	Err      error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("tmt: %s %s: %v", e.Method, e.Endpoint, e.Err)
+}
+
+func (e *TransportError) Unwrap() error { return e.Err }
+
+// StatusError is returned for a response whose HTTP status is not 200.
+type StatusError struct {
+	Method     string
+	Endpoint   string
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("tmt: %s %s: unexpected status %s", e.Method, e.Endpoint, e.Status)
+}
+
+// APIError is returned when the "status" field of a payload is not
+// "success". Messages is the "messages" field, which explains why.
+type APIError struct {
+	Endpoint string
+	Status   string
+	Messages string
+}
+
+func (e *APIError) Error() string {
+	if e.Messages == "" {
+		return fmt.Sprintf("tmt: %s: API status %q", e.Endpoint, e.Status)
+	}
+	return fmt.Sprintf("tmt: %s: API status %q: %s", e.Endpoint, e.Status, e.Messages)
+}
+
+// DecodeError is returned when a response body is not the expected JSON.
+type DecodeError struct {
+	Endpoint string
+	Body     []byte
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("tmt: decoding %s: %v", e.Endpoint, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// EmptyError is returned when a successful payload has no "data" entries.
+type EmptyError struct {
+	Endpoint string
+}
+
+func (e *EmptyError) Error() string {
+	return fmt.Sprintf("tmt: %s: no data in the response", e.Endpoint)
+}
+
+// Temporary reports whether err may go away by sending the request again:
+// transport failures, 408, 429 and 5xx statuses and bodies that could not be
+// decoded, e.g. because they were cut off. A transport failure caused by an
+// error with a Permanent method that returns true, such as a replayed
+// recording that has run out, is not temporary.
+func Temporary(err error) bool {
+	switch e := err.(type) {
+	case *TransportError:
+		var p interface{ Permanent() bool }
+		return !errors.As(e.Err, &p) || !p.Permanent()
+	case *DecodeError:
+		return true
+	case *StatusError:
+		return e.StatusCode == http.StatusRequestTimeout ||
+			e.StatusCode == http.StatusTooManyRequests ||
+			e.StatusCode >= 500
+	}
+	return false
+}
+
+// checkStatus returns an *APIError unless body has "status": "success".
+func checkStatus(endpoint string, body []byte) error {
+	var payload struct {
+		Status   string          `json:"status"`
+		Messages json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return &DecodeError{Endpoint: endpoint, Body: body, Err: err}
+	}
+	if payload.Status == "success" {
+		return nil
+	}
+	var messages string
+	if err := json.Unmarshal(payload.Messages, &messages); err != nil {
+		messages = string(payload.Messages) // not a string, e.g. a list of validation errors
+	}
+	return &APIError{Endpoint: endpoint, Status: payload.Status, Messages: messages}
+}
@@ -0,0 +1,184 @@
+package tmt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter schedules the requests of a Client so they stay within the
+// rate limit of the API. It learns the limit from the X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset headers of every response
+// and spreads the remaining requests over the rest of the window; after a
+// 429 it waits as long as Retry-After asks. One RateLimiter should be shared
+// by everything that calls the API from the same address.
+type RateLimiter struct {
+	// MinInterval is the least time between the start of two requests.
+	MinInterval time.Duration
+
+	// Reserve is how many requests of each window are left unused, for
+	// the app and anyone else calling the API from the same address. At
+	// most a tenth of the limit is held back.
+	Reserve int
+
+	// Window is assumed as the time until the limit resets when a
+	// response has no X-RateLimit-Reset header.
+	Window time.Duration
+
+	// Logf, if set, is told when a request is held back because the
+	// window is used up or Retry-After asked for it, or for spreadLogAfter
+	// or more to spread the requests, and why.
+	Logf func(format string, args ...interface{})
+
+	mu           sync.Mutex
+	state        RateLimitState
+	last         time.Time // start of the latest scheduled request
+	blockedUntil time.Time // from Retry-After
+}
+
+// RateLimitState is what a RateLimiter knows about the limit.
+type RateLimitState struct {
+	Known     bool      // false until a response had X-RateLimit headers
+	Limit     int       // requests per window
+	Remaining int       // requests left in the current window
+	Reset     time.Time // when the current window ends
+}
+
+func (s RateLimitState) String() string {
+	if !s.Known {
+		return "rate limit unknown"
+	}
+	return fmt.Sprintf("%d/%d requests left, resets in %s",
+		s.Remaining, s.Limit, time.Until(s.Reset).Round(time.Second))
+}
+
+// NewRateLimiter returns a RateLimiter that keeps at least minInterval
+// between two requests and 5 requests of each window in reserve.
+func NewRateLimiter(minInterval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		MinInterval: minInterval,
+		Reserve:     5,
+		Window:      time.Minute,
+	}
+}
+
+// State returns what l currently knows about the limit.
+func (l *RateLimiter) State() RateLimitState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+// Wait blocks until the next request may be sent, or until ctx is done.
+// Each call takes one request of the window.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	at, why := l.schedule(now)
+	l.last = at
+	if l.state.Known && l.state.Remaining > 0 {
+		l.state.Remaining-- // until the response tells better
+	}
+	state := l.state
+	l.mu.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	if why != "" && (why != whySpreading || d >= spreadLogAfter) && l.Logf != nil {
+		l.Logf("API rate limit: %s, %s, waiting %s", state, why, d.Round(time.Second))
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+const (
+	whySpreading = "spreading the remaining requests"
+
+	// spreadLogAfter is the least wait for spreading requests that is
+	// logged; shorter ones are the normal pace of a crawl.
+	spreadLogAfter = 10 * time.Second
+)
+
+// schedule returns when the next request may start and, if it is held back
+// by the limit rather than MinInterval, why.
+func (l *RateLimiter) schedule(now time.Time) (time.Time, string) {
+	at := l.last.Add(l.MinInterval)
+	why := ""
+	if l.blockedUntil.After(at) {
+		at, why = l.blockedUntil, "asked to retry later"
+	}
+	s := l.state
+	if s.Known && s.Reset.After(now) {
+		reserve := l.Reserve
+		if s.Limit > 0 && reserve > s.Limit/10 {
+			reserve = s.Limit / 10
+		}
+		if left := s.Remaining - reserve; left <= 0 {
+			if s.Reset.After(at) {
+				at, why = s.Reset, "window used up"
+			}
+		} else if spaced := l.last.Add(s.Reset.Sub(now) / time.Duration(left)); spaced.After(at) {
+			at, why = spaced, whySpreading
+		}
+	}
+	if at.Before(now) {
+		at = now
+	}
+	return at, why
+}
+
+// Update takes the rate limit headers of resp into account.
+func (l *RateLimiter) Update(resp *http.Response) {
+	h := resp.Header
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit, err := strconv.Atoi(h.Get("X-RateLimit-Limit")); err == nil {
+		l.state.Limit = limit
+	}
+	if remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining")); err == nil {
+		l.state.Known = true
+		l.state.Remaining = remaining
+		l.state.Reset = now.Add(l.Window)
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			l.state.Reset = time.Unix(reset, 0)
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait := l.Window
+		if d, ok := retryAfter(h.Get("Retry-After"), now); ok {
+			wait = d
+		}
+		l.blockedUntil = now.Add(wait)
+		if l.Logf != nil {
+			l.Logf("API rate limit: %s %s returned 429, retrying in %s",
+				resp.Request.Method, resp.Request.URL.Path, wait.Round(time.Second))
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header, either seconds or an HTTP date.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
@@ -0,0 +1,137 @@
+package tmt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSchedule(t *testing.T) {
+	now := time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		limiter *RateLimiter
+		wantIn  time.Duration // from now
+		wantWhy string
+	}{
+		{
+			name:    "first request",
+			limiter: &RateLimiter{MinInterval: 2 * time.Second},
+		},
+		{
+			name:    "min interval",
+			limiter: &RateLimiter{MinInterval: 2 * time.Second, last: now.Add(-time.Second)},
+			wantIn:  time.Second,
+		},
+		{
+			name: "plenty left",
+			limiter: &RateLimiter{Reserve: 5, last: now.Add(-time.Second),
+				state: RateLimitState{Known: true, Limit: 60, Remaining: 55, Reset: now.Add(10 * time.Second)}},
+		},
+		{
+			name: "spreading",
+			limiter: &RateLimiter{Reserve: 5, last: now,
+				state: RateLimitState{Known: true, Limit: 60, Remaining: 15, Reset: now.Add(30 * time.Second)}},
+			wantIn:  3 * time.Second,
+			wantWhy: whySpreading,
+		},
+		{
+			name: "window used up",
+			limiter: &RateLimiter{Reserve: 5, last: now,
+				state: RateLimitState{Known: true, Limit: 60, Remaining: 5, Reset: now.Add(20 * time.Second)}},
+			wantIn:  20 * time.Second,
+			wantWhy: "window used up",
+		},
+		{
+			name: "reserve at most a tenth of the limit",
+			limiter: &RateLimiter{Reserve: 5, last: now,
+				state: RateLimitState{Known: true, Limit: 10, Remaining: 2, Reset: now.Add(20 * time.Second)}},
+			wantIn:  20 * time.Second,
+			wantWhy: whySpreading,
+		},
+		{
+			name: "window over",
+			limiter: &RateLimiter{Reserve: 5, last: now,
+				state: RateLimitState{Known: true, Limit: 60, Remaining: 0, Reset: now.Add(-time.Second)}},
+		},
+		{
+			name:    "retry after",
+			limiter: &RateLimiter{MinInterval: time.Second, last: now, blockedUntil: now.Add(30 * time.Second)},
+			wantIn:  30 * time.Second,
+			wantWhy: "asked to retry later",
+		},
+	} {
+		at, why := tc.limiter.schedule(now)
+		if tc.wantIn == 0 {
+			why = "" // no wait, nothing to explain
+		}
+		if got := at.Sub(now); got != tc.wantIn || why != tc.wantWhy {
+			t.Errorf("%s: in %s (%q), want %s (%q)", tc.name, got, why, tc.wantIn, tc.wantWhy)
+		}
+	}
+}
+
+func TestRateLimiterUpdate(t *testing.T) {
+	reset := time.Now().Add(40 * time.Second).Truncate(time.Second)
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header: http.Header{
+			"X-Ratelimit-Limit":     {"60"},
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {strconv.FormatInt(reset.Unix(), 10)},
+			"Retry-After":           {"12"},
+		},
+		Request: httptest.NewRequest(http.MethodGet, "/api/getLastTrackingData", nil),
+	}
+	l := NewRateLimiter(0)
+	if l.State().Known {
+		t.Error("state known before any response")
+	}
+	l.Update(resp)
+	st := l.State()
+	if !st.Known || st.Limit != 60 || st.Remaining != 0 || !st.Reset.Equal(reset) {
+		t.Errorf("state %+v", st)
+	}
+	if d := time.Until(l.blockedUntil); d < 11*time.Second || d > 12*time.Second {
+		t.Errorf("blocked for %s, want 12s", d)
+	}
+
+	// Without X-RateLimit-Reset the window is assumed.
+	l = NewRateLimiter(0)
+	l.Update(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"X-Ratelimit-Remaining": {"7"}}})
+	if d := time.Until(l.State().Reset); d < 59*time.Second || d > time.Minute {
+		t.Errorf("reset in %s, want a minute", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2023, 11, 20, 10, 0, 0, 0, time.UTC)
+	for v, want := range map[string]time.Duration{
+		"5":                             5 * time.Second,
+		"Mon, 20 Nov 2023 10:00:30 GMT": 30 * time.Second,
+	} {
+		if d, ok := retryAfter(v, now); !ok || d != want {
+			t.Errorf("%q: %s, %v, want %s", v, d, ok, want)
+		}
+	}
+	for _, v := range []string{"", "soon"} {
+		if _, ok := retryAfter(v, now); ok {
+			t.Errorf("%q parsed", v)
+		}
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	l := NewRateLimiter(time.Hour)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,128 @@
+package tmt
+
+import "net/http"
+
+// Meta carries the parts of an HTTP response that are not in the JSON payload.
+type Meta struct {
+	Header http.Header
+	Raw    []byte // response body as received
+}
+
+type ResponseWaypoints struct {
+	Meta     `json:"-"`
+	Status   string     `json:"status"`
+	Messages string     `json:"messages"`
+	Data     []Waypoint `json:"data"`
+}
+
+type Waypoint struct {
+	WpointName    string `json:"WpointName"`
+	WpointNameEng string `json:"WpointNameEng"`
+	WPointNo      string `json:"WPointNo"`
+	Longitude     string `json:"Longitude"`
+	Latitude      string `json:"Latitude"`
+	GroupType     string `json:"group_type"`
+}
+
+type ResponseRouteMaster struct {
+	Meta     `json:"-"`
+	Status   string        `json:"status"`
+	Messages string        `json:"messages"`
+	Data     []RouteMaster `json:"data"`
+}
+
+type RouteMaster struct {
+	RouteNo        string `json:"RouteNo"`
+	RouteName      string `json:"RouteName"`
+	RouteNum       string `json:"RouteNum"`
+	RouteDirection string `json:"RouteDirection"`
+}
+
+type ResponseRouteNo struct {
+	Meta             `json:"-"`
+	Status           string        `json:"status"`
+	Messages         string        `json:"messages"`
+	Data             []Route       `json:"data"`
+	AllRouteVehicles []interface{} `json:"all_route_vehicles"`
+}
+
+type Route struct {
+	RouteNo                 int           `json:"RouteNo"`
+	RouteName               string        `json:"RouteName"`
+	RouteNum                string        `json:"RouteNum"`
+	RouteDirection          string        `json:"RouteDirection"`
+	RouteStage              interface{}   `json:"RouteStage"`
+	TotalCalculatedDistance string        `json:"total_calculated_distance"`
+	RouteDetails            []RouteDetail `json:"route_details"`
+}
+
+type RouteDetail struct {
+	RDNo       int    `json:"RDNo"`
+	RouteNo    string `json:"RouteNo"`
+	WPointNo   string `json:"WPointNo"`
+	SequenceNo string `json:"SequenceNo"`
+	Waypoints  struct {
+		WPointNo     string        `json:"WPointNo"`
+		WpointName   string        `json:"WpointName"`
+		Longitude    string        `json:"Longitude"`
+		Latitude     string        `json:"Latitude"`
+		InsertedDate string        `json:"InsertedDate"`
+		GroupType    string        `json:"group_type"`
+		InRouteNo    string        `json:"in_route_no"`
+		IsSuspected  string        `json:"is_suspected"`
+		Allvehicle   []interface{} `json:"allvehicle"`
+	} `json:"waypoints"`
+}
+
+type ResponseBusLocations struct {
+	Meta     `json:"-"`
+	Status   string        `json:"status"`
+	Messages string        `json:"messages"`
+	Data     []BusLocation `json:"data"`
+}
+
+type BusLocation struct {
+	IdxTrackidPk     int         `json:"idx_Trackid_pk"`
+	VehID            string      `json:"VehId"`
+	CmpID            string      `json:"CmpId"`
+	LastTrackdt      string      `json:"LastTrackdt"`
+	NCSent           string      `json:"NCSent"`
+	CSent            string      `json:"CSent"`
+	PrevTrackDt      string      `json:"PrevTrackDt"`
+	LastNCSentDate   string      `json:"LastNCSentDate"`
+	Longitude        string      `json:"Longitude"`
+	Latitude         string      `json:"Latitude"`
+	City             interface{} `json:"City,omitempty"`
+	Speed            string      `json:"Speed"`
+	ImagePath        interface{} `json:"ImagePath,omitempty"`
+	AC               string      `json:"AC"`
+	Ignition         string      `json:"Ignition"`
+	AUX1             string      `json:"AUX1"`
+	DI4              string      `json:"DI4"`
+	Fuel             string      `json:"Fuel"`
+	Temparature      string      `json:"Temparature"`
+	WPointNo         int         `json:"WPointNo,omitempty"`
+	Odometer         string      `json:"Odometer"`
+	Distance         string      `json:"Distance"`
+	ETATime          string      `json:"ETATime"`
+	ETARoute         string      `json:"ETARoute"`
+	ETAOldTime       string      `json:"ETAOldTime"`
+	Routeflag        string      `json:"routeflag"`
+	ETARouteName     string      `json:"ETARouteName"`
+	DirectionFrom    string      `json:"DirectionFrom"`
+	DirectionTo      string      `json:"DirectionTo"`
+	DispatchDateTime string      `json:"DispatchDateTime"`
+	ETATime1         string      `json:"ETATime1"`
+	ETAOldTime1      string      `json:"ETAOldTime1"`
+	Routeflag1       int         `json:"routeflag1,omitempty"`
+	RouteNo          string      `json:"RouteNo"`
+	WaybillNo        string      `json:"WaybillNo"`
+	Lastwaypointid   int         `json:"lastwaypointid,omitempty"`
+	Token            string      `json:"token"`
+	Avgspeed         string      `json:"avgspeed"`
+	LatLong          string      `json:"LatLong"`
+	GetVehicle       struct {
+		Vehid string `json:"vehid"`
+		VehNo string `json:"VehNo"`
+	} `json:"get_vehicle"`
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"TMTU/model"
+	"TMTU/store"
+	"TMTU/tmt"
+)
+
+const (
+	// storeTimeout bounds opening the store and draining the spool, so a
+	// database that is down does not hold up polling for long.
+	storeTimeout = 30 * time.Second
+
+	// spoolDrainBatches is how many queued batches one cycle writes, about
+	// as many cycles as spent with the database down.
+	spoolDrainBatches = 100
+)
+
+// changeDetector picks the positions worth storing out of successive
+// getLastTrackingData snapshots: the first one seen for a vehicle and every
+// one whose LastTrackdt differs from the vehicle's previous one.
+type changeDetector struct {
+	lastSeen map[string]string // VehId -> LastTrackdt
+}
+
+func newChangeDetector() *changeDetector {
+	return &changeDetector{lastSeen: make(map[string]string)}
+}
+
+// changed returns the entries of snapshot that are new and remembers them.
+func (d *changeDetector) changed(snapshot []tmt.BusLocation) []tmt.BusLocation {
+	var changed []tmt.BusLocation
+	for _, loc := range snapshot {
+		if last, ok := d.lastSeen[loc.VehID]; ok && last == loc.LastTrackdt {
+			continue
+		}
+		d.lastSeen[loc.VehID] = loc.LastTrackdt
+		changed = append(changed, loc)
+	}
+	return changed
+}
+
+// convertPositions converts entries of the tracking feed, reading their
+// timestamps in loc and counting failures in quality. Rejected entries are
+// reported and left out.
+func convertPositions(entries []tmt.BusLocation, loc *time.Location, quality *qualityReport) []model.Position {
+	positions := make([]model.Position, 0, len(entries))
+	for _, location := range entries {
+		bus, err := model.PositionFromAPI(location, loc)
+		quality.add(location.VehID, err)
+		var fieldErrs model.FieldErrors
+		if errors.As(err, &fieldErrs) && fieldErrs.Rejected() {
+			fmt.Printf("\nRejected position of bus %s: %v", location.VehID, err)
+			continue
+		} else if err != nil {
+			fmt.Printf("\nBus %s: %v", location.VehID, err)
+		}
+		positions = append(positions, bus)
+	}
+	return positions
+}
+
+// openStoreTimeout opens the store of cfg, giving up after storeTimeout.
+func openStoreTimeout(cfg *Config) (store.Store, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	st, err := openStore(ctx, cfg)
+	if err != nil {
+		return nil, err // st may be a typed nil
+	}
+	return st, nil
+}
+
+// writeSpoolStats saves the queue depth of the spool for monitoring.
+func writeSpoolStats(path string, stats store.SpoolStats) error {
+	b, err := json.MarshalIndent(struct {
+		Updated time.Time `json:"updated"`
+		store.SpoolStats
+	}{time.Now(), stats}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}